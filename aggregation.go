@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	whisper "github.com/go-graphite/go-whisper"
+)
+
+// AggregationRule is one section of a storage-aggregation.conf file.
+type AggregationRule struct {
+	Name         string
+	PatternRaw   string
+	PatternFlags string
+	MatchType    string // matchRegex, matchPrefix or matchLiteral
+	Pattern      *regexp.Regexp
+	// XFilesFactor is nil when the section does not set xFilesFactor.
+	XFilesFactor *float32
+	// AggregationMethod is zero when the section does not set aggregationMethod.
+	AggregationMethod whisper.AggregationMethod
+	LineNo            int
+}
+
+// parseStorageAggregation parses a storage-aggregation.conf file and returns its rules in
+// file order, so first match wins like for storage-schemas.conf:
+//
+// [name]
+// pattern = REGEX
+// xFilesFactor = 0.5
+// aggregationMethod = average
+//
+// Sections without any of these keys are ignored. With strict, unknown keys are an error.
+func parseStorageAggregation(path string, strict bool) ([]AggregationRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to close file %s %v\n", path, err)
+		}
+	}()
+
+	sections, err := readConfigSections(f)
+	if err != nil {
+		return nil, err
+	}
+	if strict {
+		if err = checkKnownKeys(sections); err != nil {
+			return nil, err
+		}
+	}
+
+	var rules []AggregationRule
+	for _, sec := range sections {
+		pattern := sec.get("pattern")
+		xff := sec.get("xfilesfactor")
+		method := sec.get("aggregationmethod")
+		flags := sec.get("patternflags")
+		if pattern == "" && xff == "" && method == "" {
+			// empty section: ignore
+			continue
+		}
+		rule := AggregationRule{
+			Name:         sec.Name,
+			PatternRaw:   pattern,
+			PatternFlags: flags,
+			LineNo:       sec.LineNo,
+		}
+		rule.MatchType, err = parseMatchType(sec.Name, sec.get("matchtype"), flags)
+		if err != nil {
+			return nil, err
+		}
+		if pattern != "" && rule.MatchType == matchRegex {
+			rule.Pattern, err = compileSectionPattern(sec.Name, pattern, flags)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if xff != "" {
+			v, err := strconv.ParseFloat(xff, 32)
+			if err != nil || math.IsNaN(v) || v < 0 || v > 1 {
+				return nil, fmt.Errorf("invalid xFilesFactor %q in section [%s]: must be a number between 0 and 1", xff, sec.Name)
+			}
+			f32 := float32(v)
+			rule.XFilesFactor = &f32
+		}
+		if method != "" {
+			rule.AggregationMethod, err = normalizeAggregationMethod(method)
+			if err != nil {
+				return nil, fmt.Errorf("invalid aggregationMethod in section [%s]: %v", sec.Name, err)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// aggregationMethods are the methods a whisper file can be created with, by canonical name.
+// whisper.Mix and whisper.Percentile only exist in compressed file headers and are left out.
+var aggregationMethods = map[string]whisper.AggregationMethod{
+	"average": whisper.Average,
+	"sum":     whisper.Sum,
+	"last":    whisper.Last,
+	"max":     whisper.Max,
+	"min":     whisper.Min,
+	"first":   whisper.First,
+}
+
+// aggregationMethodAliases maps other spellings seen in configs to a canonical name.
+var aggregationMethodAliases = map[string]string{
+	"avg":     "average",
+	"mean":    "average",
+	"total":   "sum",
+	"maximum": "max",
+	"minimum": "min",
+}
+
+// normalizeAggregationMethod parses an aggregation method case-insensitively, accepting the
+// aliases in aggregationMethodAliases, e.g. "AVG" -> whisper.Average. The error for an unknown
+// method lists what is accepted.
+func normalizeAggregationMethod(s string) (whisper.AggregationMethod, error) {
+	name := strings.ToLower(strings.TrimSpace(s))
+	if canonical, ok := aggregationMethodAliases[name]; ok {
+		name = canonical
+	}
+	if m, ok := aggregationMethods[name]; ok {
+		return m, nil
+	}
+	names := slices.Sorted(maps.Keys(aggregationMethods))
+	var aliases []string
+	for _, alias := range slices.Sorted(maps.Keys(aggregationMethodAliases)) {
+		aliases = append(aliases, alias+"="+aggregationMethodAliases[alias])
+	}
+	return whisper.Unknown, fmt.Errorf("unknown aggregation method %q: must be one of %s (aliases %s)", s, strings.Join(names, ", "), strings.Join(aliases, ", "))
+}
+
+// Carbon falls back to these when no storage-aggregation rule (or key) applies.
+const (
+	defaultXFilesFactor      float32 = 0.5
+	defaultAggregationMethod         = whisper.Average
+)
+
+// defaultXFFEnv overrides the --default-xff default when the flag isn't given.
+const defaultXFFEnv = "WHISPER_TOOLS_DEFAULT_XFF"
+
+// resolveDefaultXFF picks the xFilesFactor used for new files whose aggregation config doesn't
+// set one: the --default-xff flag when given explicitly, else $WHISPER_TOOLS_DEFAULT_XFF, else
+// carbon's 0.5. The result must be within [0,1].
+func resolveDefaultXFF(flagValue float64, flagSet bool) (float32, error) {
+	v, source := flagValue, "--default-xff"
+	if !flagSet {
+		env := os.Getenv(defaultXFFEnv)
+		if env == "" {
+			return defaultXFilesFactor, nil
+		}
+		parsed, err := strconv.ParseFloat(env, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s %q: %v", defaultXFFEnv, env, err)
+		}
+		v, source = parsed, defaultXFFEnv
+	}
+	if math.IsNaN(v) || v < 0 || v > 1 {
+		return 0, fmt.Errorf("invalid %s %g: must be between 0 and 1", source, v)
+	}
+	return float32(v), nil
+}
+
+// matchAggregationRule returns the first rule (top-to-bottom) whose pattern matches metric, or nil.
+func matchAggregationRule(rules []AggregationRule, metric string) *AggregationRule {
+	for i := range rules {
+		r := &rules[i]
+		if r.PatternRaw == "" {
+			continue
+		}
+		if sectionMatches(r.MatchType, r.PatternRaw, r.Pattern, metric) {
+			return r
+		}
+	}
+	return nil
+}
+
+// catchAllProbes are metric names a catch-all pattern like ".*" or ".+" must match; a rule
+// matching all of them is assumed to match everything. Metric names are never empty, so "" isn't
+// one of them.
+var catchAllProbes = []string{"a", "carbon.agents.host-1.cpuUsage", "stats_counts.x.y_z"}
+
+// matchesAllProbes reports whether a section pattern matches every one of catchAllProbes, i.e.
+// looks like ".*".
+func matchesAllProbes(matchType, raw string, re *regexp.Regexp) bool {
+	for _, m := range catchAllProbes {
+		if !sectionMatches(matchType, raw, re, m) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasCatchAll reports whether any of the rules is a catch-all such as the usual
+// "[default] pattern = .*", so that no metric falls back to whisper's built-in average / 0.5
+// unnoticed. A catch-all before the last rule hides the rules after it, see catchAllIndex.
+func hasCatchAll(rules []AggregationRule) bool {
+	return catchAllIndex(rules) >= 0
+}
+
+// catchAllIndex returns the index of the first catch-all rule, or -1 if there is none.
+func catchAllIndex(rules []AggregationRule) int {
+	for i := range rules {
+		r := &rules[i]
+		if r.PatternRaw != "" && matchesAllProbes(r.MatchType, r.PatternRaw, r.Pattern) {
+			return i
+		}
+	}
+	return -1
+}
+
+// missingCatchAllWarning is shown when hasCatchAll is false.
+const missingCatchAllWarning = "no catch-all rule (e.g. [default] pattern = .*): unmatched metrics silently get average and xFilesFactor 0.5"
+
+// resolveAggregation returns the aggregation method and xFilesFactor carbon would create metric
+// with, falling back to average and defaultXFF for anything the matching rule leaves unset.
+func resolveAggregation(rules []AggregationRule, metric string, defaultXFF float32) (whisper.AggregationMethod, float32) {
+	method, xff := defaultAggregationMethod, defaultXFF
+	if r := matchAggregationRule(rules, metric); r != nil {
+		if r.AggregationMethod != 0 {
+			method = r.AggregationMethod
+		}
+		if r.XFilesFactor != nil {
+			xff = *r.XFilesFactor
+		}
+	}
+	return method, xff
+}
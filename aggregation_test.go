@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseStorageAggregationXFilesFactor(t *testing.T) {
+	tests := []struct {
+		xff     string
+		wantErr bool
+	}{
+		{"0", false},
+		{"0.5", false},
+		{"1", false},
+		{"-0.1", true},
+		{"1.5", true},
+		{"nan", true},
+		{"NaN", true},
+		{"half", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.xff, func(t *testing.T) {
+			path := writeTestFile(t, "storage-aggregation.conf", "[default]\npattern = .*\nxFilesFactor = "+tt.xff+"\n")
+			_, err := parseStorageAggregation(path, false)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("xFilesFactor = %s: err = %v, want error %t", tt.xff, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveDefaultXFF(t *testing.T) {
+	tests := []struct {
+		name    string
+		flag    float64
+		flagSet bool
+		env     string
+		want    float32
+		wantErr bool
+	}{
+		{"default", 0, false, "", defaultXFilesFactor, false},
+		{"flag", 0.2, true, "0.7", 0.2, false},
+		{"env", 0, false, "0.7", 0.7, false},
+		{"flag NaN", math.NaN(), true, "", 0, true},
+		{"env NaN", 0, false, "nan", 0, true},
+		{"flag out of range", 2, true, "", 0, true},
+		{"env out of range", 0, false, "-1", 0, true},
+		{"env not a number", 0, false, "half", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(defaultXFFEnv, tt.env)
+			got, err := resolveDefaultXFF(tt.flag, tt.flagSet)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("got %g, want %g", got, tt.want)
+			}
+			if err != nil && !strings.Contains(err.Error(), "invalid") {
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestCatchAllIndex(t *testing.T) {
+	tests := []struct {
+		name string
+		conf string
+		want int
+	}{
+		{"last", "[sum]\npattern = \\.count$\naggregationMethod = sum\n\n[default]\npattern = .*\n", 1},
+		{"first", "[default]\npattern = .*\n\n[sum]\npattern = \\.count$\naggregationMethod = sum\n", 0},
+		{"dot plus", "[sum]\npattern = \\.count$\naggregationMethod = sum\n\n[default]\npattern = .+\n", 1},
+		{"anchored dot plus", "[default]\npattern = ^.+$\n\n[sum]\npattern = \\.count$\naggregationMethod = sum\n", 0},
+		{"none", "[sum]\npattern = \\.count$\naggregationMethod = sum\n", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := parseStorageAggregation(writeTestFile(t, "storage-aggregation.conf", tt.conf), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := catchAllIndex(rules); got != tt.want {
+				t.Errorf("catchAllIndex = %d, want %d", got, tt.want)
+			}
+			if got := hasCatchAll(rules); got != (tt.want >= 0) {
+				t.Errorf("hasCatchAll = %t, want %t", got, tt.want >= 0)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	whisper "github.com/go-graphite/go-whisper"
+)
+
+// go-whisper only exposes time-range fetches that pick an archive for the caller. The helpers
+// below read the classic (uncompressed) on-disk layout directly so individual archives can be
+// inspected point by point:
+//
+//	metadata:     aggregation(uint32) maxRetention(uint32) xFilesFactor(float32) archiveCount(uint32)
+//	archive info: offset(uint32) secondsPerPoint(uint32) points(uint32), one per archive
+//	data:         interval(uint32) value(float64), points*12 bytes per archive
+//
+// All values are big-endian.
+
+// compressedMagic is the prefix of go-whisper's compressed format, which uses a different layout.
+var compressedMagic = []byte("whisper_compressed")
+
+// errCompressedWhisper is returned by readWhisperHeader for files in go-whisper's compressed
+// format.
+var errCompressedWhisper = errors.New("compressed whisper files are not supported")
+
+// maxArchives bounds the archive count read from a header, so a corrupt one fails instead of
+// allocating gigabytes for its archive info. Real files have a handful of archives.
+const maxArchives = 64
+
+// archiveHeader describes one archive as stored in a classic whisper header.
+type archiveHeader struct {
+	Offset          int64
+	SecondsPerPoint int
+	Points          int
+}
+
+// Retention returns how many seconds of data the archive covers.
+func (a archiveHeader) Retention() int {
+	return a.SecondsPerPoint * a.Points
+}
+
+// whisperHeader is the decoded metadata block of a classic whisper file.
+type whisperHeader struct {
+	AggregationMethod whisper.AggregationMethod
+	MaxRetention      int
+	XFilesFactor      float32
+	Archives          []archiveHeader
+}
+
+// dataPoint is a single (timestamp, value) slot of an archive.
+type dataPoint struct {
+	Timestamp int
+	Value     float64
+}
+
+// readWhisperHeader decodes the metadata and archive info of a classic whisper file.
+func readWhisperHeader(r io.ReaderAt) (*whisperHeader, error) {
+	meta := make([]byte, whisper.MetadataSize)
+	if _, err := r.ReadAt(meta, 0); err != nil {
+		return nil, fmt.Errorf("unable to read header: %v", err)
+	}
+	if bytes.HasPrefix(meta, compressedMagic[:len(meta)]) {
+		return nil, errCompressedWhisper
+	}
+
+	h := &whisperHeader{
+		AggregationMethod: whisper.AggregationMethod(binary.BigEndian.Uint32(meta[0:4])),
+		MaxRetention:      int(binary.BigEndian.Uint32(meta[4:8])),
+		XFilesFactor:      math.Float32frombits(binary.BigEndian.Uint32(meta[8:12])),
+	}
+	count := int(binary.BigEndian.Uint32(meta[12:16]))
+	if count > maxArchives {
+		return nil, fmt.Errorf("unable to read archive info: implausible archive count %d", count)
+	}
+
+	info := make([]byte, whisper.ArchiveInfoSize*count)
+	if _, err := r.ReadAt(info, whisper.MetadataSize); err != nil {
+		return nil, fmt.Errorf("unable to read archive info: %v", err)
+	}
+	for i := 0; i < count; i++ {
+		b := info[i*whisper.ArchiveInfoSize:]
+		a := archiveHeader{
+			Offset:          int64(binary.BigEndian.Uint32(b[0:4])),
+			SecondsPerPoint: int(binary.BigEndian.Uint32(b[4:8])),
+			Points:          int(binary.BigEndian.Uint32(b[8:12])),
+		}
+		// everything addressing slots divides by these, a corrupt header must not get that far
+		if a.SecondsPerPoint == 0 || a.Points == 0 {
+			return nil, fmt.Errorf("archive %d has %d seconds per point and %d points, the header is corrupt", i, a.SecondsPerPoint, a.Points)
+		}
+		h.Archives = append(h.Archives, a)
+	}
+	return h, nil
+}
+
+// readArchiveData reads the raw bytes of archive a. When the size of r is known, an archive
+// reaching past its end fails before its points are allocated: a corrupt header could claim
+// billions of them.
+func readArchiveData(r io.ReaderAt, a archiveHeader) ([]byte, error) {
+	end := a.Offset + int64(a.Points)*whisper.PointSize
+	if size, ok := readerSize(r); ok && end > size {
+		return nil, fmt.Errorf("unable to read archive data: archive ends at offset %d, past the end of the %d byte file", end, size)
+	}
+	buf := make([]byte, a.Points*whisper.PointSize)
+	if _, err := r.ReadAt(buf, a.Offset); err != nil {
+		return nil, fmt.Errorf("unable to read archive data: %v", err)
+	}
+	return buf, nil
+}
+
+// readerSize returns the size of r if it is a file or knows its size, like bytes.Reader.
+func readerSize(r io.ReaderAt) (int64, bool) {
+	switch r := r.(type) {
+	case interface{ Size() int64 }:
+		return r.Size(), true
+	case interface{ Stat() (os.FileInfo, error) }:
+		st, err := r.Stat()
+		if err != nil {
+			return 0, false
+		}
+		return st.Size(), true
+	}
+	return 0, false
+}
+
+// readArchivePoints returns every slot of the archive that holds a point within its retention
+// window ending at now, sorted oldest first. Slots that were never written or that hold data
+// from a previous pass of the ring buffer are skipped, which is what whisper reports as null.
+func readArchivePoints(r io.ReaderAt, a archiveHeader, now int) ([]dataPoint, error) {
+	buf, err := readArchiveData(r, a)
+	if err != nil {
+		return nil, err
+	}
+
+	oldest := now - a.Retention()
+	out := make([]dataPoint, 0, a.Points)
+	for i := 0; i < a.Points; i++ {
+		b := buf[i*whisper.PointSize:]
+		ts := int(binary.BigEndian.Uint32(b[0:4]))
+		if ts == 0 || ts <= oldest || ts > now {
+			continue
+		}
+		out = append(out, dataPoint{
+			Timestamp: ts,
+			Value:     math.Float64frombits(binary.BigEndian.Uint64(b[4:12])),
+		})
+	}
+	// the ring buffer wraps around, so slot order is not time order
+	sort.Slice(out, func(i, j int) bool { return out[i].Timestamp < out[j].Timestamp })
+	return out, nil
+}
+
+// readArchiveSlots returns the raw slots of the archive in on-disk order, including slots that
+// were never written (timestamp 0) or hold stale data.
+func readArchiveSlots(r io.ReaderAt, a archiveHeader) ([]dataPoint, error) {
+	buf, err := readArchiveData(r, a)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]dataPoint, a.Points)
+	for i := range out {
+		b := buf[i*whisper.PointSize:]
+		out[i] = dataPoint{
+			Timestamp: int(binary.BigEndian.Uint32(b[0:4])),
+			Value:     math.Float64frombits(binary.BigEndian.Uint64(b[4:12])),
+		}
+	}
+	return out, nil
+}
+
+// printArchiveLayout prints the physical layout of the classic whisper file at path: the header
+// size and, per archive, the byte range its points occupy.
+func printArchiveLayout(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to close file %s %v\n", path, err)
+		}
+	}()
+
+	h, err := readWhisperHeader(f)
+	if err != nil {
+		return err
+	}
+	headerSize := whisper.MetadataSize + len(h.Archives)*whisper.ArchiveInfoSize
+	fmt.Printf("Header size: %d bytes (%d metadata + %d archive info)\n", headerSize, whisper.MetadataSize, len(h.Archives)*whisper.ArchiveInfoSize)
+	fmt.Println()
+
+	wr := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(wr, "archive\toffset\t#points\tbytes\tend offset")
+	for i, a := range h.Archives {
+		size := int64(a.Points * whisper.PointSize)
+		_, _ = fmt.Fprintf(wr, "%d\t%d\t%d\t%d\t%d\n", i, a.Offset, a.Points, size, a.Offset+size)
+	}
+	return wr.Flush()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// testHeader encodes a classic whisper header with one archive per {secondsPerPoint, points}
+// pair, average aggregation and xFilesFactor 0.5.
+func testHeader(archives ...[2]uint32) []byte {
+	var b bytes.Buffer
+	_ = binary.Write(&b, binary.BigEndian, []uint32{1, 0, 0x3f000000, uint32(len(archives))})
+	offset := uint32(16 + 12*len(archives))
+	for _, a := range archives {
+		_ = binary.Write(&b, binary.BigEndian, []uint32{offset, a[0], a[1]})
+		offset += a[1] * 12
+	}
+	return b.Bytes()
+}
+
+func TestReadWhisperHeaderRejectsZeroArchives(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  []byte
+		wantErr string
+	}{
+		{"zero resolution", testHeader([2]uint32{60, 1440}, [2]uint32{0, 100}), "archive 1 has 0 seconds per point"},
+		{"zero points", testHeader([2]uint32{60, 0}), "archive 0 has 60 seconds per point and 0 points"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readWhisperHeader(bytes.NewReader(tt.header))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	h, err := readWhisperHeader(bytes.NewReader(testHeader([2]uint32{60, 1440}, [2]uint32{3600, 8760})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Archives) != 2 || h.Archives[1].Retention() != 3600*8760 || h.XFilesFactor != 0.5 {
+		t.Errorf("got %+v", h)
+	}
+}
+
+func TestReadArchivePointsPastEndOfFile(t *testing.T) {
+	// a corrupt header claiming a billion points in a file that holds none of them
+	data := testHeader([2]uint32{60, 1_000_000_000})
+	h, err := readWhisperHeader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, read := range map[string]func() error{
+		"points": func() error { _, err := readArchivePoints(bytes.NewReader(data), h.Archives[0], 0); return err },
+		"slots":  func() error { _, err := readArchiveSlots(bytes.NewReader(data), h.Archives[0]); return err },
+	} {
+		if err := read(); err == nil || !strings.Contains(err.Error(), "past the end of the 28 byte file") {
+			t.Errorf("%s: err = %v, want archive past the end of the file", name, err)
+		}
+	}
+}
+
+func TestReadWhisperHeaderArchiveCount(t *testing.T) {
+	header := testHeader([2]uint32{60, 1440})
+	binary.BigEndian.PutUint32(header[12:16], maxArchives+1)
+	if _, err := readWhisperHeader(bytes.NewReader(header)); err == nil || !strings.Contains(err.Error(), "implausible archive count") {
+		t.Errorf("err = %v, want implausible archive count", err)
+	}
+}
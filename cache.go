@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// checkCacheEntry is the cached check result of one .wsp file together with the file
+// attributes it is valid for.
+type checkCacheEntry struct {
+	ModTime int64       `json:"mtime"` // unix nanoseconds
+	Size    int64       `json:"size"`
+	Result  checkResult `json:"result"`
+}
+
+// checkCache holds the results of a previous --check-retention run so files whose mtime and
+// size didn't change don't have to be opened again. Key identifies the schemas and options the
+// results were computed with; a cache with a different key is discarded. Files is keyed by
+// cacheFileKey: the metric name in a result depends on the root the file was found under.
+type checkCache struct {
+	Key   string                     `json:"key"`
+	Files map[string]checkCacheEntry `json:"files"`
+
+	// seen collects the entries of the current run, so files that disappeared are dropped
+	// when the cache is saved.
+	seen map[string]checkCacheEntry
+	mu   sync.Mutex
+}
+
+// checkCacheKey derives the cache key from the contents of the schemas file, with
+// checkOptions.All also of the aggregation file, and the options that influence a file's result.
+func checkCacheKey(schemasPath, aggregationPath string, opts checkOptions) (string, error) {
+	h := sha256.New()
+	if err := hashConfig(h, schemasPath); err != nil {
+		return "", fmt.Errorf("failed to read schemas %s: %v", schemasPath, err)
+	}
+	// graded and comments mark results carrying a mismatch severity and schema comments, so
+	// caches written before them are dropped
+	// rooted marks caches keyed by root and path, see cacheFileKey
+	_, _ = fmt.Fprintf(h, "\x00finer=%t tolerance=%d/%d graded comments rooted", opts.ReportFiner, opts.Tolerance.Points, opts.Tolerance.Seconds)
+	// the metric names of the results depend on these
+	_, _ = fmt.Fprintf(h, " nonormalize=%t extensions=%q", opts.Walk.NoNormalize, opts.Walk.Extensions)
+	if opts.All {
+		_, _ = fmt.Fprintf(h, " all xff=%g\x00", opts.DefaultXFF)
+		if err := hashConfig(h, aggregationPath); err != nil {
+			return "", fmt.Errorf("failed to read aggregation %s: %v", aggregationPath, err)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashConfig writes the contents of the config file at path to w.
+func hashConfig(w io.Writer, path string) error {
+	f, err := openConfig(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// loadCheckCache reads the cache at path. A missing or unreadable cache, or one written for a
+// different key, yields an empty cache.
+func loadCheckCache(path, key string) *checkCache {
+	c := &checkCache{Key: key, Files: map[string]checkCacheEntry{}, seen: map[string]checkCacheEntry{}}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "warning: ignoring cache %s: %v\n", path, err)
+		}
+		return c
+	}
+	var prev checkCache
+	if err := json.Unmarshal(data, &prev); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: ignoring cache %s: %v\n", path, err)
+		return c
+	}
+	if prev.Key == key && prev.Files != nil {
+		c.Files = prev.Files
+	}
+	return c
+}
+
+// cacheFileKey identifies the file at path found under root in checkCache.Files.
+func cacheFileKey(root, path string) string {
+	return root + "\x00" + path
+}
+
+// lookup returns the cached result for the file at path under root if it still has the
+// recorded mtime and size.
+func (c *checkCache) lookup(root, path string, st os.FileInfo) (checkResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.Files[cacheFileKey(root, path)]
+	if !ok || e.ModTime != st.ModTime().UnixNano() || e.Size != st.Size() {
+		return checkResult{}, false
+	}
+	return e.Result, true
+}
+
+// store records the result for the file at path under root in the current run. lookup and
+// store are safe for concurrent use.
+func (c *checkCache) store(root, path string, st os.FileInfo, res checkResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seen[cacheFileKey(root, path)] = checkCacheEntry{ModTime: st.ModTime().UnixNano(), Size: st.Size(), Result: res}
+}
+
+// save writes the entries of the current run to path, replacing it atomically.
+func (c *checkCache) save(path string) error {
+	data, err := json.Marshal(checkCache{Key: c.Key, Files: c.seen})
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	whisper "github.com/go-graphite/go-whisper"
+)
+
+func TestCheckCacheSeparatesRoots(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(root, "servers", "web01", "cpu.wsp")
+	writeTestWhisper(t, file, "1m:1d", whisper.Average, 0.5)
+	schemas, err := readStorageSchemas(strings.NewReader("[servers]\npattern = ^servers\\.\nretentions = 1m:1d\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := checkOptions{Cache: loadCheckCache(filepath.Join(t.TempDir(), "cache.json"), "key")}
+
+	for range 2 {
+		res := checkFileCached(root, file, schemas, opts)
+		if res.Metric != "servers.web01.cpu" || res.Status != "OK" {
+			t.Errorf("under %s: got %s %s, want OK servers.web01.cpu", root, res.Status, res.Metric)
+		}
+		sub := filepath.Join(root, "servers")
+		res = checkFileCached(sub, file, schemas, opts)
+		if res.Metric != "web01.cpu" || res.Status != "NOMATCH" {
+			t.Errorf("under %s: got %s %s, want NOMATCH web01.cpu", sub, res.Status, res.Metric)
+		}
+		// the second round is served from the entries stored by the first
+		opts.Cache.Files = opts.Cache.seen
+	}
+}
+
+func TestCheckCacheKeyOptions(t *testing.T) {
+	schemas := writeTestFile(t, "storage-schemas.conf", "[servers]\npattern = ^servers\\.\nretentions = 1m:1d\n")
+	key := func(opts checkOptions) string {
+		t.Helper()
+		k, err := checkCacheKey(schemas, "", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	base := key(checkOptions{})
+	for name, opts := range map[string]checkOptions{
+		"no-normalize": {Walk: walkOptions{NoNormalize: true}},
+		"extension":    {Walk: walkOptions{Extensions: []string{".wsp.bak"}}},
+		"report-finer": {ReportFiner: true},
+	} {
+		if key(opts) == base {
+			t.Errorf("%s doesn't change the cache key", name)
+		}
+	}
+}
@@ -0,0 +1,856 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// checkResult is the outcome of checking one .wsp file against storage-schemas.
+type checkResult struct {
+	Source     string // root the file was found under
+	Status     string // OK, MISMATCH, FINER, NOMATCH or ERROR
+	Metric     string
+	Path       string
+	SchemaName string // empty for NOMATCH
+	// SchemaComments are the comments above the matched schema, see Schema.Comments.
+	SchemaComments []string
+	Expected       []ArchiveSpec
+	Actual         []ArchiveSpec
+	Detail         string
+	// Severity grades a MISMATCH or FINER: severityWarn or severityError, see classifyMismatch.
+	Severity string
+	// Differs names the attributes that differ from the configs, only set with checkOptions.All:
+	// retentions, aggregation and xFilesFactor.
+	Differs []string
+	// Trace lists the schemas tried before the match, only set with checkOptions.Explain.
+	// It is not cached, as it only depends on the metric name.
+	Trace []schemaTrial `json:"-"`
+	// timedOut is set when reading the file hit checkOptions.FileTimeout, such results are
+	// never cached.
+	timedOut bool
+}
+
+// Severities of a retention mismatch.
+const (
+	severityWarn  = "WARN"  // the file keeps at least the history the schema asks for
+	severityError = "ERROR" // the file loses history the schema asks for
+)
+
+// failOnLevels are the values accepted by --fail-on.
+var failOnLevels = []string{"warn", "error"}
+
+// failed reports whether the result should make the run exit non-zero. With failOn "error",
+// mismatches of severityWarn don't count.
+func (r checkResult) failed(failOn string) bool {
+	switch r.Status {
+	case "ERROR":
+		return true
+	case "MISMATCH", "FINER":
+		return failOn != "error" || r.Severity == severityError
+	}
+	return false
+}
+
+// classifyMismatch grades mismatching retentions spec by spec: every expected archive must be
+// covered by an actual one at least as fine that keeps at least as long. If all of them are,
+// the file only differs in harmless ways (longer or finer history, extra archives) and the
+// severity is severityWarn, otherwise it is severityError and the reason names the first
+// uncovered spec.
+func classifyMismatch(actual, expected []ArchiveSpec) (severity, reason string) {
+	for _, e := range expected {
+		covered := false
+		for _, a := range actual {
+			if a.SecondsPerPoint <= e.SecondsPerPoint && a.RetentionSecs >= e.RetentionSecs {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return severityError, fmt.Sprintf("%s not kept", e.toHuman())
+		}
+	}
+	return severityWarn, "keeps at least the expected history"
+}
+
+// pointCounts renders the points of every archive in actual vs expected for --verbose, with
+// the difference where they disagree, e.g. "points: 0: 2160 vs 2160, 1: 43200 vs 10080 (+33120)".
+// Archives only one side has are shown as "-".
+func pointCounts(actual, expected []ArchiveSpec) string {
+	parts := make([]string, 0, max(len(actual), len(expected)))
+	for i := range max(len(actual), len(expected)) {
+		a, e := "-", "-"
+		if i < len(actual) {
+			a = strconv.Itoa(actual[i].points())
+		}
+		if i < len(expected) {
+			e = strconv.Itoa(expected[i].points())
+		}
+		part := fmt.Sprintf("%d: %s vs %s", i, a, e)
+		if i < len(actual) && i < len(expected) && actual[i].points() != expected[i].points() {
+			part += fmt.Sprintf(" (%+d)", actual[i].points()-expected[i].points())
+		}
+		parts = append(parts, part)
+	}
+	return "points: " + strings.Join(parts, ", ")
+}
+
+// addPointCounts appends the per-archive point counts to the detail of a MISMATCH or FINER
+// result, see pointCounts.
+func (r *checkResult) addPointCounts() {
+	if r.Status == "MISMATCH" || r.Status == "FINER" {
+		r.Detail += "; " + pointCounts(r.Actual, r.Expected)
+	}
+}
+
+// cells renders the result as the columns shared by all output formats.
+func (r checkResult) cells(labeled bool, opts checkOptions) []string {
+	expected, actual := "-", "-"
+	switch r.Status {
+	case "OK":
+		expected = formatRetentionList(r.Expected)
+		actual = formatRetentionList(r.Actual)
+	case "MISMATCH", "FINER":
+		expected = "expected:" + formatRetentionList(r.Expected)
+		actual = "got:" + formatRetentionList(r.Actual)
+	}
+	var out []string
+	if labeled {
+		out = append(out, r.Source)
+	}
+	out = append(out, r.Status, r.Metric, expected, actual, r.Detail)
+	if opts.ShowPath {
+		out = append(out, r.Path)
+	}
+	return out
+}
+
+// csvCells renders the result for the csv audit format: like cells but with a schema column,
+// plain retention lists and empty fields where there is nothing to report, so every row has
+// the same machine readable shape.
+func (r checkResult) csvCells(labeled bool, opts checkOptions) []string {
+	var expected, actual string
+	if r.Status != "NOMATCH" && r.Status != "ERROR" {
+		expected = formatRetentionList(r.Expected)
+		actual = formatRetentionList(r.Actual)
+	}
+	var out []string
+	if labeled {
+		out = append(out, r.Source)
+	}
+	out = append(out, r.Status, r.Metric, r.SchemaName, expected, actual, r.Detail)
+	if opts.ShowPath {
+		out = append(out, r.Path)
+	}
+	return out
+}
+
+// checkOptions tweaks the output of checkRetentions.
+type checkOptions struct {
+	// ShowPath adds a trailing path column with the .wsp file each row was derived from.
+	ShowPath bool
+	// Format is one of checkFormats.
+	Format string
+	// ReportFiner reports mismatching files whose finest archive is finer than the schema's
+	// as FINER rather than MISMATCH: they waste space and are candidates for downsampling.
+	ReportFiner bool
+	// AllowEmpty turns a root without any .wsp files into a warning instead of an error.
+	AllowEmpty bool
+	// Tolerance is how far an archive's retention may be off from the schema's and still be OK.
+	Tolerance retentionTolerance
+	// Cache, when set, supplies results for files unchanged since the previous run and
+	// records the results of this one.
+	Cache *checkCache
+	// TableStyle is one of tableStyles, used by the table format.
+	TableStyle string
+	// Template renders every result for the template format, see parseCheckTemplate.
+	Template *template.Template
+	// FailOn is one of failOnLevels: the lowest mismatch severity that fails the run.
+	FailOn string
+	// Tally, when set, counts the results per status for --push-gateway and --score.
+	Tally *checkTally
+	// Stream writes every result as soon as it is known, in file order, rather than after the
+	// whole run, see streamCheckLines.
+	Stream bool
+	// Score leaves out the per-file results, only opts.Tally is filled for --score.
+	Score bool
+	// Timing, when set, accumulates the time spent per phase for --timing.
+	Timing *runTiming
+	// Verbose appends the points of every archive, actual vs expected, to mismatch details.
+	Verbose bool
+	// All also compares the aggregation method and xFilesFactor of every file against
+	// AggregationRules and reports a single status for all three, see compareAggregation.
+	All bool
+	// AggregationRules are the storage-aggregation rules compared against with All.
+	AggregationRules []AggregationRule
+	// DefaultXFF is the xFilesFactor expected for metrics AggregationRules leave it unset for.
+	DefaultXFF float32
+	// Explain adds the ordered list of schemas each metric was tested against to the output.
+	Explain bool
+	// FileTimeout, when positive, is how long opening and reading a single file may take
+	// before it is reported as an ERROR and the check moves on.
+	FileTimeout time.Duration
+	// Workers is the number of files checked concurrently, at least one.
+	Workers int
+	// Walk selects which files under a root are checked.
+	Walk walkOptions
+	// Query restricts the check to files whose metric name matches this Graphite glob.
+	Query string
+}
+
+// retentionTolerance is the allowed difference between the retention of a file's archive and
+// the schema's. Graphite rounds retentions to whole points when creating files, so an exact
+// comparison can flag files that are correct for all practical purposes.
+type retentionTolerance struct {
+	Points  int // scaled by the archive's resolution
+	Seconds int
+}
+
+// parseTolerance parses a --tolerance value: a plain number is a count of points, a number
+// with a unit suffix (e.g. "5m") is a duration.
+func parseTolerance(s string) (retentionTolerance, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 0 {
+			return retentionTolerance{}, fmt.Errorf("invalid tolerance %q: must not be negative", s)
+		}
+		return retentionTolerance{Points: n}, nil
+	}
+	secs, err := fromHuman(s)
+	if err != nil {
+		return retentionTolerance{}, fmt.Errorf("invalid tolerance %q: %v", s, err)
+	}
+	if secs < 0 {
+		return retentionTolerance{}, fmt.Errorf("invalid tolerance %q: must not be negative", s)
+	}
+	return retentionTolerance{Seconds: secs}, nil
+}
+
+// compareSpecsWithin is like specsEqualNormalized but accepts retentions that differ from the
+// normalized expected ones by no more than tol. Resolutions must still match exactly.
+func compareSpecsWithin(actual, expected []ArchiveSpec, tol retentionTolerance) bool {
+	if len(actual) != len(expected) {
+		return false
+	}
+	for i := range actual {
+		if actual[i].SecondsPerPoint != expected[i].SecondsPerPoint {
+			return false
+		}
+		allowed := tol.Seconds + tol.Points*expected[i].SecondsPerPoint
+		diff := actual[i].RetentionSecs - expected[i].normalized().RetentionSecs
+		if diff < -allowed || diff > allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// checkFormats are the output formats accepted by --format for --check-retention.
+var checkFormats = []string{"table", "plain", "csv", "json", "template"}
+
+// parseCheckTemplate parses the --template text, or the file templateFile, for the template
+// format. Every result is rendered as a checkRecord, so the fields are .Source, .Status,
+// .Metric, .Schema, .SchemaComments, .Expected, .Actual, .Detail, .Severity, .Path and, with --explain,
+// .MatchTrace. A newline is added unless the template ends with one. The template is tried on
+// an empty record, so a misspelled field fails before any file is read.
+func parseCheckTemplate(text, templateFile string) (*template.Template, error) {
+	name := "--template"
+	if templateFile != "" {
+		data, err := os.ReadFile(templateFile)
+		if err != nil {
+			return nil, err
+		}
+		text, name = string(data), templateFile
+	}
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err = tmpl.Execute(io.Discard, checkRecord{}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// checkJob is a file queued for checking. Index is its position in the output.
+type checkJob struct {
+	Index      int
+	Root, Path string
+}
+
+// listCheckJobs finds the .wsp files to check under every root, numbered in output order.
+func listCheckJobs(ctx context.Context, roots []string, opts checkOptions) ([]checkJob, error) {
+	defer opts.Timing.since(phaseWalk, time.Now())
+	// find all .wsp files under every root before checking anything
+	files := make([][]string, len(roots))
+	for i, root := range roots {
+		var found []string
+		var err error
+		if opts.Query != "" {
+			found, err = expandGraphiteGlob(root, opts.Query)
+			found = slices.DeleteFunc(found, func(f string) bool { return !opts.Walk.keepMetric(root, f) })
+		} else {
+			found, err = findWhisperFilesCtx(ctx, root, opts.Walk)
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed walking root %s: %v", root, err)
+		}
+		if len(found) == 0 {
+			what := ".wsp files"
+			if opts.Query != "" {
+				what = fmt.Sprintf(".wsp files matching %q", opts.Query)
+			}
+			if !opts.AllowEmpty {
+				return nil, fmt.Errorf("no %s found under %s", what, root)
+			}
+			fmt.Fprintf(os.Stderr, "warning: no %s found under %s\n", what, root)
+		}
+		files[i] = found
+	}
+
+	var jobs []checkJob
+	for i, root := range roots {
+		for _, f := range files[i] {
+			jobs = append(jobs, checkJob{Index: len(jobs), Root: root, Path: f})
+		}
+	}
+	opts.Timing.addFiles(len(jobs))
+	return jobs, nil
+}
+
+// runCheckJobs checks jobs on opts.Workers goroutines and hands every result to emit, which
+// may be called concurrently and in any order. It returns once all jobs are done. Once ctx is
+// done no further jobs are started, the ones already running are finished and emitted.
+// With a window, every job takes a slot in it before it is started, in job order; the caller
+// frees the slot once done with the result. That bounds how far checking may run ahead of
+// the caller. A nil window doesn't limit anything.
+func runCheckJobs(ctx context.Context, jobs []checkJob, schemas []Schema, opts checkOptions, window chan struct{}, emit func(int, checkResult)) {
+	queue := make(chan checkJob)
+	var wg sync.WaitGroup
+	for range max(opts.Workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				emit(j.Index, checkFileCached(j.Root, j.Path, schemas, opts))
+			}
+		}()
+	}
+queueing:
+	for _, j := range jobs {
+		if window != nil {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				break queueing
+			}
+		}
+		select {
+		case queue <- j:
+		case <-ctx.Done():
+			break queueing
+		}
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// collectCheckResults compares the retentions of every .wsp file under roots against the
+// first matching schema. Metric names are derived relative to the root a file was found under.
+// When ctx is done before all files are checked, ctx.Err() is returned.
+func collectCheckResults(ctx context.Context, roots []string, schemas []Schema, opts checkOptions) ([]checkResult, error) {
+	jobs, err := listCheckJobs(ctx, roots, opts)
+	if err != nil {
+		return nil, err
+	}
+	collector := newCheckResultCollector(len(jobs))
+	runCheckJobs(ctx, jobs, schemas, opts, nil, collector.add)
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	return collector.all(), nil
+}
+
+// checkFileCached is checkFile consulting and updating opts.Cache when one is set. With
+// opts.Explain the result also gets its match trace.
+func checkFileCached(root, f string, schemas []Schema, opts checkOptions) checkResult {
+	var res checkResult
+	st, err := os.Stat(f)
+	if opts.Cache == nil || err != nil {
+		res = checkFile(root, f, schemas, opts)
+	} else {
+		var ok bool
+		res, ok = opts.Cache.lookup(root, f, st)
+		if !ok {
+			res = checkFile(root, f, schemas, opts)
+		}
+		if !res.timedOut {
+			opts.Cache.store(root, f, st, res)
+		}
+		res.Source = root
+	}
+	if opts.Explain {
+		res.Trace = explainMatch(schemas, res.Metric)
+	}
+	if opts.Verbose {
+		res.addPointCounts()
+	}
+	opts.Tally.add(res)
+	return res
+}
+
+// checkResultCollector gathers check results from concurrent workers. Results are slotted by
+// the index their file was queued with, so the output order doesn't depend on scheduling.
+type checkResultCollector struct {
+	mu      sync.Mutex
+	results []checkResult
+}
+
+// newCheckResultCollector returns a collector for n results.
+func newCheckResultCollector(n int) *checkResultCollector {
+	return &checkResultCollector{results: make([]checkResult, n)}
+}
+
+// add stores the result of the i-th queued file. It is safe for concurrent use.
+func (c *checkResultCollector) add(i int, r checkResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results[i] = r
+}
+
+// all returns the collected results in queue order.
+func (c *checkResultCollector) all() []checkResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.results
+}
+
+// checkFile compares the retentions of the .wsp file f found under root against the first
+// schema matching its metric name.
+func checkFile(root, f string, schemas []Schema, opts checkOptions) checkResult {
+	res := checkResult{Source: root, Metric: opts.Walk.metricName(root, f), Path: f}
+	return checkMetric(res, schemas, opts, func() (fileAttrs, error) {
+		return readWithTimeout(opts.FileTimeout, func() (fileAttrs, error) { return readFileAttrs(f) })
+	})
+}
+
+// errFileTimeout is returned by readWithTimeout when read didn't finish in time.
+var errFileTimeout = errors.New("timeout")
+
+// readWithTimeout runs read in a goroutine and waits at most timeout for it, or forever when
+// timeout isn't positive. A read that timed out is abandoned rather than cancelled, a hanging
+// open on network storage can't be interrupted; its result is dropped whenever it returns.
+func readWithTimeout[T any](timeout time.Duration, read func() (T, error)) (T, error) {
+	if timeout <= 0 {
+		return read()
+	}
+	type result struct {
+		v   T
+		err error
+	}
+	// buffered, so an abandoned read can still deliver its result and exit
+	done := make(chan result, 1)
+	go func() {
+		v, err := read()
+		done <- result{v, err}
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.v, r.err
+	case <-timer.C:
+		var zero T
+		return zero, fmt.Errorf("%w after %s", errFileTimeout, timeout)
+	}
+}
+
+// checkMetric completes res, which has its metric set, by comparing the retentions returned by
+// readAttrs against the first schema matching the metric, and with opts.All also the
+// aggregation method and xFilesFactor against storage-aggregation. readAttrs is only called
+// when a schema matches.
+func checkMetric(res checkResult, schemas []Schema, opts checkOptions, readAttrs func() (fileAttrs, error)) checkResult {
+	start := time.Now()
+	matched := matchSchema(schemas, res.Metric)
+	if matched == nil {
+		// no schema matched
+		res.Status = "NOMATCH"
+		res.Detail = "no schema matched"
+		opts.Timing.since(phaseCompare, start)
+		return res
+	}
+	res.SchemaName = matched.Name
+	res.SchemaComments = matched.Comments
+	res.Expected = matched.Retentions
+	opts.Timing.since(phaseCompare, start)
+
+	// open whisper file and read retentions
+	start = time.Now()
+	attrs, err := readAttrs()
+	opts.Timing.since(phaseOpen, start)
+	if errors.Is(err, errFileTimeout) {
+		res.Status = "ERROR"
+		res.Detail = err.Error()
+		res.timedOut = true
+		return res
+	}
+	if err != nil {
+		res.Status = "ERROR"
+		res.Detail = fmt.Sprintf("failed to open: %v", err)
+		return res
+	}
+	res.Actual = attrs.Specs
+	defer opts.Timing.since(phaseCompare, time.Now())
+
+	if specsEqualNormalized(res.Actual, res.Expected) {
+		res.Status = "OK"
+		res.Detail = fmt.Sprintf("matched schema[%s]", matched.Name)
+	} else if compareSpecsWithin(res.Actual, res.Expected, opts.Tolerance) {
+		res.Status = "OK"
+		res.Detail = fmt.Sprintf("matched schema[%s] within tolerance", matched.Name)
+	} else if actual, expected := finestResolution(res.Actual), finestResolution(res.Expected); opts.ReportFiner && actual < expected {
+		res.Status = "FINER"
+		var reason string
+		res.Severity, reason = classifyMismatch(res.Actual, res.Expected)
+		res.Detail = fmt.Sprintf("schema[%s]: finest %s is finer than %s, %s %s", matched.Name, toHuman(actual), toHuman(expected), res.Severity, reason)
+	} else {
+		res.Status = "MISMATCH"
+		var reason string
+		res.Severity, reason = classifyMismatch(res.Actual, res.Expected)
+		res.Detail = fmt.Sprintf("schema[%s]: %s %s", matched.Name, res.Severity, reason)
+	}
+	if opts.All {
+		res.compareAggregation(attrs, opts)
+	}
+	return res
+}
+
+// compareAggregation folds the aggregation method and xFilesFactor of attrs into the result of
+// the retention check for --all, so a file gets a single status: one that differs in either is
+// a MISMATCH, and as it rolls up its data wrongly rather than losing history, of
+// severityError. res.Differs lists every attribute that differs from what the configs expect
+// and the detail starts with them, e.g. "differs in retentions, xFilesFactor (got 0, want
+// 0.5)".
+func (r *checkResult) compareAggregation(attrs fileAttrs, opts checkOptions) {
+	method, xff := resolveAggregation(opts.AggregationRules, r.Metric, opts.DefaultXFF)
+	var details []string
+	if r.Status != "OK" {
+		r.Differs = append(r.Differs, "retentions")
+		details = append(details, "retentions")
+	}
+	if attrs.AggregationMethod != method {
+		r.Differs = append(r.Differs, "aggregation")
+		details = append(details, fmt.Sprintf("aggregation (got %s, want %s)", attrs.AggregationMethod, method))
+	}
+	if attrs.XFilesFactor != xff {
+		r.Differs = append(r.Differs, "xFilesFactor")
+		details = append(details, fmt.Sprintf("xFilesFactor (got %g, want %g)", attrs.XFilesFactor, xff))
+	}
+	if len(details) == 0 {
+		r.Detail += fmt.Sprintf(", aggregation %s, xFilesFactor %g", method, xff)
+		return
+	}
+	if len(r.Differs) > 1 || r.Differs[0] != "retentions" {
+		switch {
+		case r.Status == "OK":
+			r.Status = "MISMATCH"
+			r.Detail = fmt.Sprintf("schema[%s]: %s retentions match", r.SchemaName, severityError)
+		case r.Severity == severityWarn:
+			r.Detail = fmt.Sprintf("schema[%s]: %s retentions keep at least the expected history", r.SchemaName, severityError)
+		}
+		r.Severity = severityError
+	}
+	r.Detail = fmt.Sprintf("differs in %s; %s", strings.Join(details, ", "), r.Detail)
+}
+
+// checkHeader returns the column names matching checkResult.cells or, for the csv format,
+// checkResult.csvCells.
+func checkHeader(labeled bool, opts checkOptions) []string {
+	header := []string{"status", "metric", "expected", "actual", "detail"}
+	if opts.Format == "csv" {
+		header = slices.Insert(header, 2, "schema")
+	}
+	if labeled {
+		header = append([]string{"source"}, header...)
+	}
+	if opts.ShowPath {
+		header = append(header, "path")
+	}
+	return header
+}
+
+// writeCheckResults writes results to w in opts.Format. json is only used for results that
+// aren't streamed, see streamCheckResultsJSON. With more than one root every row is
+// prefixed with its root as a source label.
+func writeCheckResults(w io.Writer, results []checkResult, labeled bool, opts checkOptions) error {
+	header := checkHeader(labeled, opts)
+	switch opts.Format {
+	case "csv":
+		// retention lists contain commas, the csv writer quotes them
+		cw := csv.NewWriter(w)
+		_ = cw.Write(header)
+		for _, r := range results {
+			_ = cw.Write(r.csvCells(labeled, opts))
+		}
+		cw.Flush()
+		return cw.Error()
+	case "json":
+		// same layout as streamCheckResultsJSON
+		bw := bufio.NewWriter(w)
+		_, _ = bw.WriteString("[")
+		for i, r := range results {
+			data, err := json.Marshal(r.record(labeled, opts))
+			if err != nil {
+				return err
+			}
+			if i > 0 {
+				_, _ = bw.WriteString(",")
+			}
+			_, _ = bw.WriteString("\n  ")
+			_, _ = bw.Write(data)
+		}
+		if len(results) > 0 {
+			_, _ = bw.WriteString("\n")
+		}
+		_, _ = bw.WriteString("]\n")
+		return bw.Flush()
+	case "template":
+		bw := bufio.NewWriter(w)
+		for _, r := range results {
+			// unlike the other formats the path is always there, the template picks what to show
+			if err := opts.Template.Execute(bw, r.record(true, checkOptions{ShowPath: true})); err != nil {
+				return err
+			}
+		}
+		return bw.Flush()
+	case "plain":
+		for _, r := range results {
+			if _, err := fmt.Fprintln(w, strings.Join(r.cells(labeled, opts), " ")); err != nil {
+				return err
+			}
+			for _, t := range r.Trace {
+				if _, err := fmt.Fprintln(w, "  "+t.describe()); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	default:
+		tw := newTableWriter(w, opts.TableStyle)
+		tw.header(header...)
+		for _, r := range results {
+			cells := r.cells(labeled, opts)
+			tw.row(cells...)
+			// trace lines go below the last column: in a plain table they are trailing text that
+			// neither widens it nor, having all the other cells, breaks the alignment of the
+			// rows that follow
+			for _, t := range r.Trace {
+				tw.row(append(make([]string, len(cells)-1), t.describe())...)
+			}
+		}
+		return tw.flush()
+	}
+}
+
+// checkRecord is the JSON form of a checkResult.
+type checkRecord struct {
+	Source string `json:"source,omitempty"`
+	Status string `json:"status"`
+	Metric string `json:"metric"`
+	Schema string `json:"schema,omitempty"`
+	// SchemaComments are the comments above the matched schema, e.g. "owner: team-x".
+	SchemaComments []string `json:"schemaComments,omitempty"`
+	Expected       string   `json:"expected,omitempty"`
+	Actual         string   `json:"actual,omitempty"`
+	Detail         string   `json:"detail"`
+	Severity       string   `json:"severity,omitempty"`
+	Differs        []string `json:"differs,omitempty"`
+	Path           string   `json:"path,omitempty"`
+	// MatchTrace is only set with --explain.
+	MatchTrace []schemaTrial `json:"matchTrace,omitempty"`
+}
+
+// record converts the result for JSON output, leaving out the source unless labeled and the
+// path unless opts.ShowPath.
+func (r checkResult) record(labeled bool, opts checkOptions) checkRecord {
+	rec := checkRecord{Status: r.Status, Metric: r.Metric, Schema: r.SchemaName, SchemaComments: r.SchemaComments, Detail: r.Detail, Severity: r.Severity, Differs: r.Differs, MatchTrace: r.Trace}
+	if labeled {
+		rec.Source = r.Source
+	}
+	if r.Expected != nil {
+		rec.Expected = formatRetentionList(r.Expected)
+	}
+	if r.Actual != nil {
+		rec.Actual = formatRetentionList(r.Actual)
+	}
+	if opts.ShowPath {
+		rec.Path = r.Path
+	}
+	return rec
+}
+
+// streamCheckResults checks every .wsp file under roots and hands the results to write in file
+// order while the workers are still running. Results arrive out of order; they are held back
+// only until every earlier one has been written. Checking runs at most streamWindow files
+// ahead of the oldest result not yet written, so a slow file doesn't make every later result
+// pile up in memory: the whole run is never buffered. After
+// write fails the remaining results are only counted. It returns true if any mismatch or
+// error was found. When ctx is done the results so far have been written and ctx.Err() is
+// returned.
+func streamCheckResults(ctx context.Context, roots []string, schemas []Schema, opts checkOptions, write func(i int, r checkResult) error) (bool, error) {
+	jobs, err := listCheckJobs(ctx, roots, opts)
+	if err != nil {
+		return false, err
+	}
+	type indexed struct {
+		index int
+		res   checkResult
+	}
+	results := make(chan indexed, max(opts.Workers, 1))
+	window := make(chan struct{}, streamWindow(opts.Workers))
+	go func() {
+		runCheckJobs(ctx, jobs, schemas, opts, window, func(i int, r checkResult) { results <- indexed{i, r} })
+		close(results)
+	}()
+
+	pending := map[int]checkResult{}
+	next, mismatchFound := 0, false
+	var writeErr error
+	for ir := range results {
+		pending[ir.index] = ir.res
+		for r, ok := pending[next]; ok; r, ok = pending[next] {
+			delete(pending, next)
+			if r.failed(opts.FailOn) {
+				mismatchFound = true
+			}
+			if writeErr == nil {
+				writeErr = write(next, r)
+			}
+			<-window
+			next++
+		}
+	}
+	if writeErr != nil {
+		return mismatchFound, writeErr
+	}
+	return mismatchFound, ctx.Err()
+}
+
+// streamWindow is how many files streamCheckResults checks ahead of the oldest result not yet
+// written: enough to keep all workers busy while one of them is stuck on a slow file.
+func streamWindow(workers int) int {
+	return 2 * max(workers, 1)
+}
+
+// streamCheckResultsJSON checks every .wsp file under roots and writes the results to w as a
+// JSON array while the workers are still running, see streamCheckResults. With opts.Stream
+// every result is flushed as soon as it is written. The array is closed even when ctx is done.
+func streamCheckResultsJSON(ctx context.Context, w io.Writer, roots []string, schemas []Schema, opts checkOptions) (bool, error) {
+	labeled := len(roots) > 1
+	bw := bufio.NewWriter(w)
+	_, _ = bw.WriteString("[")
+	written := 0
+	mismatchFound, err := streamCheckResults(ctx, roots, schemas, opts, func(i int, r checkResult) error {
+		data, err := json.Marshal(r.record(labeled, opts))
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			_, _ = bw.WriteString(",")
+		}
+		_, _ = bw.WriteString("\n  ")
+		_, _ = bw.Write(data)
+		written++
+		if opts.Stream {
+			return bw.Flush()
+		}
+		return nil
+	})
+	if written > 0 {
+		_, _ = bw.WriteString("\n")
+	}
+	_, _ = bw.WriteString("]\n")
+	if err != nil && !errors.Is(err, ctx.Err()) {
+		return mismatchFound, err
+	}
+	if err = bw.Flush(); err != nil {
+		return mismatchFound, err
+	}
+	return mismatchFound, ctx.Err()
+}
+
+// streamCheckLines is --stream for the line based formats: every result is written to w as
+// soon as it and all earlier ones are known, see streamCheckResults, instead of after the
+// whole run. The table format can't be aligned without seeing every row first, it is written
+// like plain.
+func streamCheckLines(ctx context.Context, w io.Writer, roots []string, schemas []Schema, opts checkOptions) (bool, error) {
+	labeled := len(roots) > 1
+	var write func(i int, r checkResult) error
+	switch opts.Format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		_ = cw.Write(checkHeader(labeled, opts))
+		cw.Flush()
+		write = func(_ int, r checkResult) error {
+			_ = cw.Write(r.csvCells(labeled, opts))
+			cw.Flush()
+			return cw.Error()
+		}
+	case "template":
+		bw := bufio.NewWriter(w)
+		write = func(_ int, r checkResult) error {
+			if err := opts.Template.Execute(bw, r.record(true, checkOptions{ShowPath: true})); err != nil {
+				return err
+			}
+			return bw.Flush()
+		}
+	default:
+		write = func(_ int, r checkResult) error {
+			return writeCheckResults(w, []checkResult{r}, labeled, checkOptions{ShowPath: opts.ShowPath, Format: "plain"})
+		}
+	}
+	return streamCheckResults(ctx, roots, schemas, opts, write)
+}
+
+// checkRetentions checks every .wsp file under roots and prints one row per file to stdout,
+// unless opts.Score is set. It returns true if any mismatch or error was found. Cancelling ctx aborts the check between
+// files with ctx.Err().
+func checkRetentions(ctx context.Context, roots []string, schemas []Schema, opts checkOptions) (bool, error) {
+	if opts.Format == "json" && !opts.Score {
+		return streamCheckResultsJSON(ctx, os.Stdout, roots, schemas, opts)
+	}
+	if opts.Stream && !opts.Score {
+		return streamCheckLines(ctx, os.Stdout, roots, schemas, opts)
+	}
+	results, err := collectCheckResults(ctx, roots, schemas, opts)
+	if err != nil {
+		return false, err
+	}
+	mismatchFound := false
+	for _, r := range results {
+		if r.failed(opts.FailOn) {
+			mismatchFound = true
+		}
+	}
+	if opts.Score {
+		return mismatchFound, nil
+	}
+	return mismatchFound, writeCheckResults(os.Stdout, results, len(roots) > 1, opts)
+}
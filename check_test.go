@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	whisper "github.com/go-graphite/go-whisper"
+)
+
+func TestStreamCheckResultsBoundsSlowFile(t *testing.T) {
+	root := t.TempDir()
+	// a.wsp sorts first and blocks whoever opens it until a writer shows up
+	fifo := filepath.Join(root, "a.wsp")
+	if err := syscall.Mkfifo(fifo, 0o644); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+	const files = 20
+	for i := range files {
+		writeTestWhisper(t, filepath.Join(root, fmt.Sprintf("b%02d.wsp", i)), "1m:1d", whisper.Average, 0.5)
+	}
+	schemas, err := readStorageSchemas(strings.NewReader("[all]\npattern = .*\nretentions = 1m:1d\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := checkOptions{Workers: 2, Tally: newCheckTally()}
+
+	var written []string
+	done := make(chan error, 1)
+	go func() {
+		_, err := streamCheckResults(context.Background(), []string{root}, schemas, opts, func(i int, r checkResult) error {
+			written = append(written, r.Metric)
+			return nil
+		})
+		done <- err
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	if checked := opts.Tally.score().Files; checked > streamWindow(opts.Workers) {
+		t.Errorf("%d files checked while the first one is stuck, want at most %d", checked, streamWindow(opts.Workers))
+	}
+	// unblock the reader of the fifo, it gets an empty file
+	w, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != files+1 || written[0] != "a" || written[files] != fmt.Sprintf("b%02d", files-1) {
+		t.Errorf("written %v, want a, b00 .. b%02d in order", written, files-1)
+	}
+}
+
+func TestStreamCheckResultsBoundsSlowWriter(t *testing.T) {
+	root := t.TempDir()
+	const files = 50
+	for i := range files {
+		writeTestWhisper(t, filepath.Join(root, fmt.Sprintf("m%02d.wsp", i)), "1m:1d", whisper.Average, 0.5)
+	}
+	schemas, err := readStorageSchemas(strings.NewReader("[all]\npattern = .*\nretentions = 1m:1d\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := checkOptions{Workers: 4, Tally: newCheckTally()}
+
+	// the writer is stuck on the first result, everything checked meanwhile is held in memory
+	release := make(chan struct{})
+	var written []string
+	done := make(chan error, 1)
+	go func() {
+		_, err := streamCheckResults(context.Background(), []string{root}, schemas, opts, func(i int, r checkResult) error {
+			if i == 0 {
+				<-release
+			}
+			written = append(written, r.Metric)
+			return nil
+		})
+		done <- err
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	if retained := opts.Tally.score().Files; retained > streamWindow(opts.Workers) {
+		t.Errorf("%d results held while the writer is stuck, want at most %d", retained, streamWindow(opts.Workers))
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != files || written[0] != "m00" || written[files-1] != fmt.Sprintf("m%02d", files-1) {
+		t.Errorf("written %v, want m00 .. m%02d in order", written, files-1)
+	}
+}
+
+// BenchmarkStreamCheckResults streams the check of trees of growing size and reports the peak
+// live heap seen while writing, less what the sorted list of files takes. That stays flat:
+// results are written as they come in rather than collected.
+func BenchmarkStreamCheckResults(b *testing.B) {
+	schemas, err := readStorageSchemas(strings.NewReader("[all]\npattern = .*\nretentions = 1m:1h\n"), false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	liveHeap := func() uint64 {
+		var m runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&m)
+		return m.HeapAlloc
+	}
+	for _, files := range []int{500, 2000, 8000} {
+		b.Run(fmt.Sprintf("files=%d", files), func(b *testing.B) {
+			root := b.TempDir()
+			for i := range files {
+				writeTestWhisper(b, filepath.Join(root, fmt.Sprintf("d%02d", i%100), fmt.Sprintf("m%05d.wsp", i)), "1m:1h", whisper.Average, 0.5)
+			}
+			opts := checkOptions{Workers: 4}
+
+			base := liveHeap()
+			jobs, err := listCheckJobs(context.Background(), []string{root}, opts)
+			if err != nil {
+				b.Fatal(err)
+			}
+			after := liveHeap()
+			listed := after - min(base, after)
+			runtime.KeepAlive(jobs)
+
+			var peak uint64
+			for b.Loop() {
+				base := liveHeap() + listed
+				written := 0
+				_, err := streamCheckResults(context.Background(), []string{root}, schemas, opts, func(i int, r checkResult) error {
+					_, err := fmt.Fprintln(io.Discard, r.Metric, r.Status)
+					if written++; written%250 == 0 {
+						if heap := liveHeap(); heap > base {
+							peak = max(peak, heap-base)
+						}
+					}
+					return err
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(peak)/1024, "peak-heap-KB")
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// completionShells are the shells --completion can generate a script for.
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// confFileFlags take a path to a config file, completed with *.conf files.
+var confFileFlags = map[string]bool{"schemas": true, "aggregation": true, "config": true}
+
+// isBoolFlag reports whether f is a boolean flag, i.e. takes no value.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// writeCompletion writes a completion script for shell covering every registered flag.
+// Config-file flags complete *.conf files and the positional arguments complete
+// directories and .wsp files.
+func writeCompletion(w io.Writer, shell, prog string) error {
+	prog = filepath.Base(prog)
+	var flags []*flag.Flag
+	flag.VisitAll(func(f *flag.Flag) { flags = append(flags, f) })
+
+	switch shell {
+	case "bash":
+		fn := "_" + strings.NewReplacer("-", "_", ".", "_").Replace(prog)
+		var all, valued, conf []string
+		for _, f := range flags {
+			all = append(all, "--"+f.Name)
+			if confFileFlags[f.Name] {
+				conf = append(conf, "--"+f.Name)
+			} else if !isBoolFlag(f) {
+				valued = append(valued, "--"+f.Name)
+			}
+		}
+		_, err := fmt.Fprintf(w, `%[1]s() {
+    local cur prev
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    prev="${COMP_WORDS[COMP_CWORD-1]}"
+    case "$prev" in
+        %[2]s)
+            COMPREPLY=( $(compgen -d -- "$cur") $(compgen -f -X '!*.conf' -- "$cur") )
+            return ;;
+        %[3]s)
+            COMPREPLY=()
+            return ;;
+    esac
+    if [[ "$cur" == -* ]]; then
+        COMPREPLY=( $(compgen -W "%[4]s" -- "$cur") )
+        return
+    fi
+    COMPREPLY=( $(compgen -d -- "$cur") $(compgen -f -X '!*.wsp' -- "$cur") )
+}
+complete -o filenames -F %[1]s %[5]s
+`, fn, strings.Join(conf, "|"), strings.Join(valued, "|"), strings.Join(all, " "), prog)
+		return err
+	case "zsh":
+		// _arguments descriptions may not contain brackets or colons
+		desc := strings.NewReplacer("[", "(", "]", ")", ":", " ", "'", "'\\''", "\n", " ")
+		lines := []string{"#compdef " + prog, "", "_arguments \\"}
+		for _, f := range flags {
+			spec := fmt.Sprintf("--%s[%s]", f.Name, desc.Replace(f.Usage))
+			switch {
+			case confFileFlags[f.Name]:
+				spec = fmt.Sprintf("--%s=[%s]:file:_files -g '*.conf'", f.Name, desc.Replace(f.Usage))
+			case !isBoolFlag(f):
+				spec = fmt.Sprintf("--%s=[%s]:value: ", f.Name, desc.Replace(f.Usage))
+			}
+			lines = append(lines, fmt.Sprintf("  '%s' \\", strings.ReplaceAll(spec, "'", "'\\''")))
+		}
+		lines = append(lines, `  '*:path:_files -g "*.wsp"'`)
+		_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+		return err
+	case "fish":
+		quote := strings.NewReplacer(`\`, `\\`, "'", `\'`, "\n", " ")
+		for _, f := range flags {
+			line := fmt.Sprintf("complete -c %s -l %s -d '%s'", prog, f.Name, quote.Replace(f.Usage))
+			switch {
+			case confFileFlags[f.Name]:
+				line += " -r -a '(__fish_complete_suffix .conf)'"
+			case !isBoolFlag(f):
+				line += " -x"
+			}
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+		_, err := fmt.Fprintf(w, "complete -c %s -a '(__fish_complete_directories; __fish_complete_suffix .wsp)'\n", prog)
+		return err
+	default:
+		return fmt.Errorf("unsupported shell %q: must be one of %s", shell, strings.Join(completionShells, ", "))
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// configEntry is a single key = value line of a Graphite ini-style config file.
+type configEntry struct {
+	Key    string // lowercased
+	Value  string
+	LineNo int
+	// Indent is the width of the whitespace before the key, only lines indented deeper
+	// continue its value.
+	Indent int
+}
+
+// configSection is a [name] section of a Graphite ini-style config file with its
+// entries in file order.
+type configSection struct {
+	Name    string
+	LineNo  int
+	Entries []configEntry
+	// Comments are the whole-line comments directly above the section header, without the
+	// leading #, e.g. "owner: team-x".
+	Comments []string
+}
+
+// get returns the value of key in the section. When a key is repeated the last one wins,
+// like Python's ConfigParser.
+func (s configSection) get(key string) string {
+	val := ""
+	for _, e := range s.Entries {
+		if e.Key == key {
+			val = e.Value
+		}
+	}
+	return val
+}
+
+// stripInlineComment drops a trailing "# comment" from s.
+func stripInlineComment(s string) string {
+	if i := strings.Index(s, "#"); i >= 0 {
+		return strings.TrimSpace(s[:i])
+	}
+	return s
+}
+
+// utf8BOM is the byte order mark some Windows editors put at the start of UTF-8 files.
+const utf8BOM = "\ufeff"
+
+// defaultMaxConfigLine is the default of maxConfigLine. bufio.Scanner's own 64KB limit is too
+// small for generated configs with very long pattern or retentions lines.
+const defaultMaxConfigLine = 1 << 20
+
+// maxConfigLine is the longest line readConfigSections accepts, in bytes (--max-config-line).
+var maxConfigLine = defaultMaxConfigLine
+
+// stdinConfig is the config path that reads the file from stdin instead, as in --schemas -.
+const stdinConfig = "-"
+
+// stdinConfigData holds stdin once a config has been read from it.
+var stdinConfigData struct {
+	once sync.Once
+	data []byte
+	err  error
+}
+
+// openConfig opens the config file at path, or stdin for stdinConfig. Stdin is read on first
+// use and kept in memory, so a piped config can be opened more than once in a run.
+func openConfig(path string) (io.ReadCloser, error) {
+	if path != stdinConfig {
+		return os.Open(path)
+	}
+	stdinConfigData.once.Do(func() {
+		stdinConfigData.data, stdinConfigData.err = io.ReadAll(os.Stdin)
+	})
+	if stdinConfigData.err != nil {
+		return nil, stdinConfigData.err
+	}
+	return io.NopCloser(bytes.NewReader(stdinConfigData.data)), nil
+}
+
+// readConfigSections splits a storage-schemas.conf / storage-aggregation.conf style file into
+// sections. Lines starting with # are ignored, as are inline # comments everywhere except in
+// pattern values, where # is part of the regex. Lines indented deeper than the previous key
+// continue its value and are appended to it joined with a newline, like Python's ConfigParser;
+// a config indenting all its keys alike has no continuation lines.
+// Keys appearing before the first section header are dropped. A leading UTF-8 BOM and CRLF
+// line endings are accepted.
+func readConfigSections(r io.Reader) ([]configSection, error) {
+	scanner := bufio.NewScanner(r)
+	// the scanner accepts tokens up to the larger of its buffer's capacity and max
+	scanner.Buffer(make([]byte, 0, min(64*1024, maxConfigLine)), maxConfigLine)
+	var sections []configSection
+	var cur *configSection
+	lineNo := 0
+	// comments collects the comment block read since the last blank or key line, it belongs to
+	// the section header that follows
+	var comments []string
+
+	for scanner.Scan() {
+		lineNo++
+		// skip blank lines before converting to a string, they make up a good part of a
+		// typical file and would otherwise each cost an allocation
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			comments = nil
+			continue
+		}
+		if raw[0] == '#' {
+			comments = append(comments, strings.TrimSpace(string(raw[1:])))
+			continue
+		}
+		// files saved on Windows may start with a UTF-8 BOM and end lines with CRLF
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if lineNo == 1 {
+			line = strings.TrimPrefix(line, utf8BOM)
+		}
+		trim := strings.TrimSpace(line)
+		// a comment on a first line starting with a BOM is only recognized here
+		if strings.HasPrefix(trim, "#") {
+			comments = append(comments, strings.TrimSpace(trim[1:]))
+			continue
+		}
+		if trim == "" {
+			continue
+		}
+		// lines indented deeper than the previous key continue its value. parseRetentionList
+		// trims around commas so a wrapped "retentions = 10s:6h,\n    1m:7d" parses the same
+		// as the single-line form.
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		if cur != nil && len(cur.Entries) > 0 && indent > cur.Entries[len(cur.Entries)-1].Indent {
+			last := &cur.Entries[len(cur.Entries)-1]
+			if last.Key != "pattern" {
+				trim = stripInlineComment(trim)
+			}
+			if trim != "" {
+				last.Value += "\n" + trim
+			}
+			continue
+		}
+		// section header
+		if header := stripInlineComment(trim); strings.HasPrefix(header, "[") && strings.HasSuffix(header, "]") {
+			sections = append(sections, configSection{
+				Name:     strings.TrimSpace(header[1 : len(header)-1]),
+				LineNo:   lineNo,
+				Comments: comments,
+			})
+			cur = &sections[len(sections)-1]
+			comments = nil
+			continue
+		}
+		// key = value lines
+		if eq := strings.Index(trim, "="); eq >= 0 && cur != nil {
+			key := strings.ToLower(strings.TrimSpace(trim[:eq]))
+			val := strings.TrimSpace(trim[eq+1:])
+			// a regex may legitimately contain '#', so only pattern values keep it
+			if key != "pattern" {
+				val = stripInlineComment(val)
+			}
+			cur.Entries = append(cur.Entries, configEntry{
+				Key:    key,
+				Value:  val,
+				LineNo: lineNo,
+				Indent: indent,
+			})
+		}
+		comments = nil
+	}
+	if err := scanner.Err(); err != nil {
+		if err == bufio.ErrTooLong {
+			return nil, fmt.Errorf("line %d is longer than %d bytes, raise --max-config-line", lineNo+1, maxConfigLine)
+		}
+		return nil, err
+	}
+	return sections, nil
+}
+
+// knownSectionKeys are the keys understood in storage-schemas.conf and storage-aggregation.conf
+// sections, lowercased.
+var knownSectionKeys = []string{"pattern", "patternflags", "matchtype", "retentions", "xfilesfactor", "aggregationmethod"}
+
+// checkKnownKeys returns an error for the first key in sections that isn't one of
+// knownSectionKeys, so typos like "retension" don't go unnoticed.
+func checkKnownKeys(sections []configSection) error {
+	for _, sec := range sections {
+		for _, e := range sec.Entries {
+			if !slices.Contains(knownSectionKeys, e.Key) {
+				return fmt.Errorf("unknown key %q in section [%s] at line %d", e.Key, sec.Name, e.LineNo)
+			}
+		}
+	}
+	return nil
+}
+
+// Values of the matchType key of a section. Prefix and literal patterns are compared as plain
+// strings, which is faster than a regex and needs no escaping of dots.
+const (
+	matchRegex   = "regex"
+	matchPrefix  = "prefix"
+	matchLiteral = "literal"
+)
+
+// parseMatchType validates the matchType of section name, defaulting to regex. patternFlags
+// only apply to regexes, so combining them with another match type is an error.
+func parseMatchType(name, matchType, flags string) (string, error) {
+	switch strings.ToLower(matchType) {
+	case "", matchRegex:
+		return matchRegex, nil
+	case matchPrefix, matchLiteral:
+		if flags != "" {
+			return "", fmt.Errorf("patternFlags can't be used with matchType %s in section [%s]", matchType, name)
+		}
+		return strings.ToLower(matchType), nil
+	}
+	return "", fmt.Errorf("unknown matchType %q in section [%s]: must be regex, prefix or literal", matchType, name)
+}
+
+// sectionMatches reports whether metric matches a section's pattern: raw is the pattern as
+// written and re its compiled form, which is only set for regex sections.
+func sectionMatches(matchType, raw string, re *regexp.Regexp, metric string) bool {
+	switch matchType {
+	case matchPrefix:
+		return strings.HasPrefix(metric, raw)
+	case matchLiteral:
+		return metric == raw
+	}
+	return re != nil && re.MatchString(metric)
+}
+
+// validPatternFlags are the RE2 flags accepted by the patternFlags key of a section.
+const validPatternFlags = "imsU"
+
+// compileSectionPattern compiles the pattern of section name, prepending flags as (?flags).
+// Unknown flags are rejected so typos surface at parse time.
+func compileSectionPattern(name, pattern, flags string) (*regexp.Regexp, error) {
+	expr := pattern
+	if flags != "" {
+		for _, c := range flags {
+			if !strings.ContainsRune(validPatternFlags, c) {
+				return nil, fmt.Errorf("unknown patternFlags %q in section [%s]: supported flags are %q", string(c), name, validPatternFlags)
+			}
+		}
+		expr = "(?" + flags + ")" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("failed compiling pattern %q in section [%s]: %v", pattern, name, err)
+	}
+	return re, nil
+}
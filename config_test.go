@@ -0,0 +1,160 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadStorageSchemasWrappedRetentions(t *testing.T) {
+	conf := "[carbon]\n" +
+		"pattern = ^carbon\\.\n" +
+		"retentions = 10s:6h,\n" +
+		"    1m:7d,\n" +
+		"\t1h:2y\n"
+	schemas, err := readStorageSchemas(strings.NewReader(conf), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schemas) != 1 {
+		t.Fatalf("got %d schemas, want 1", len(schemas))
+	}
+	want, _ := parseRetentionList("10s:6h,1m:7d,1h:2y")
+	if !reflect.DeepEqual(schemas[0].Retentions, want) {
+		t.Errorf("retentions = %v, want %v", schemas[0].Retentions, want)
+	}
+}
+
+func TestReadConfigSectionsIndentedKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		conf string
+		want []configEntry
+	}{
+		{
+			name: "all keys indented alike",
+			conf: "[carbon]\n  pattern = ^carbon\\.\n  retentions = 60s:90d\n",
+			want: []configEntry{
+				{Key: "pattern", Value: `^carbon\.`, LineNo: 2, Indent: 2},
+				{Key: "retentions", Value: "60s:90d", LineNo: 3, Indent: 2},
+			},
+		},
+		{
+			name: "continuation indented deeper than its key",
+			conf: "[carbon]\n  pattern = ^carbon\\.\n  retentions = 60s:90d,\n    1h:2y\n",
+			want: []configEntry{
+				{Key: "pattern", Value: `^carbon\.`, LineNo: 2, Indent: 2},
+				{Key: "retentions", Value: "60s:90d,\n1h:2y", LineNo: 3, Indent: 2},
+			},
+		},
+		{
+			name: "inline comment on continuation",
+			conf: "[carbon]\nretentions = 60s:90d, # fine\n  1h:2y # coarse\n",
+			want: []configEntry{
+				{Key: "retentions", Value: "60s:90d,\n1h:2y", LineNo: 2},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sections, err := readConfigSections(strings.NewReader(tt.conf))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sections) != 1 {
+				t.Fatalf("got %d sections, want 1", len(sections))
+			}
+			if !reflect.DeepEqual(sections[0].Entries, tt.want) {
+				t.Errorf("entries = %#v, want %#v", sections[0].Entries, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndentedKeysMatchMetric(t *testing.T) {
+	conf := "[carbon]\n  pattern = ^carbon\\.\n  retentions = 60s:90d\n"
+	schemas, err := readStorageSchemas(strings.NewReader(conf), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := matchSchema(schemas, "carbon.x"); s == nil || s.Name != "carbon" {
+		t.Errorf("matchSchema(carbon.x) = %v, want [carbon]", s)
+	}
+}
+
+func TestReadStorageSchemasInlineComments(t *testing.T) {
+	conf := "[hashed]\n" +
+		"pattern = ^stats\\.#tag\\.\n" +
+		"retentions = 10s:6h # note\n"
+	schemas, err := readStorageSchemas(strings.NewReader(conf), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schemas) != 1 {
+		t.Fatalf("got %d schemas, want 1", len(schemas))
+	}
+	if want := `^stats\.#tag\.`; schemas[0].PatternRaw != want {
+		t.Errorf("pattern = %q, want %q", schemas[0].PatternRaw, want)
+	}
+	want, _ := parseRetentionList("10s:6h")
+	if !reflect.DeepEqual(schemas[0].Retentions, want) {
+		t.Errorf("retentions = %v, want %v", schemas[0].Retentions, want)
+	}
+}
+
+func TestParseStorageSchemasBOMAndCRLF(t *testing.T) {
+	for name, conf := range map[string]string{
+		"header first":  utf8BOM + "[carbon]\r\npattern = ^carbon\\.\r\nretentions = 60s:90d\r\n\r\n[default]\r\npattern = .*\r\nretentions = 1m:7d\r\n",
+		"comment first": utf8BOM + "# schemas\r\n[carbon]\r\npattern = ^carbon\\.\r\nretentions = 60s:90d\r\n\r\n[default]\r\npattern = .*\r\nretentions = 1m:7d\r\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			schemas, err := parseStorageSchemas(writeTestFile(t, "storage-schemas.conf", conf), true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(schemas) != 2 || schemas[0].Name != "carbon" || schemas[1].Name != "default" {
+				t.Fatalf("got %+v, want [carbon] and [default]", schemas)
+			}
+			if schemas[0].PatternRaw != `^carbon\.` {
+				t.Errorf("pattern = %q, want %q", schemas[0].PatternRaw, `^carbon\.`)
+			}
+			want, _ := parseRetentionList("60s:90d")
+			if !reflect.DeepEqual(schemas[0].Retentions, want) {
+				t.Errorf("retentions = %v, want %v", schemas[0].Retentions, want)
+			}
+		})
+	}
+}
+
+func TestReadConfigSectionsLongLine(t *testing.T) {
+	long := strings.Repeat("a", 400*1024)
+	conf := "[long]\nmatchType = literal\npattern = " + long + "\nretentions = 1m:7d\n"
+	schemas, err := readStorageSchemas(strings.NewReader(conf), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schemas) != 1 || schemas[0].PatternRaw != long {
+		t.Fatalf("long pattern not parsed")
+	}
+
+	defer func(n int) { maxConfigLine = n }(maxConfigLine)
+	maxConfigLine = 64 * 1024
+	_, err = readStorageSchemas(strings.NewReader(conf), false)
+	if err == nil || !strings.Contains(err.Error(), "line 3 is longer than") {
+		t.Errorf("err = %v, want line 3 too long", err)
+	}
+}
+
+func TestReadConfigSectionsSmallMaxLine(t *testing.T) {
+	defer func(n int) { maxConfigLine = n }(maxConfigLine)
+	maxConfigLine = 100
+	short := "[short]\npattern = ^carbon\\.\nretentions = 1m:7d\n"
+	if _, err := readStorageSchemas(strings.NewReader(short), false); err != nil {
+		t.Fatalf("short lines: %v", err)
+	}
+	long := "[long]\npattern = " + strings.Repeat("a", 200) + "\nretentions = 1m:7d\n"
+	_, err := readStorageSchemas(strings.NewReader(long), false)
+	if err == nil || !strings.Contains(err.Error(), "line 2 is longer than 100 bytes") {
+		t.Errorf("err = %v, want line 2 too long", err)
+	}
+}
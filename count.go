@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// schemaCount is the number of metrics a schema matched first.
+type schemaCount struct {
+	Schema *Schema
+	Count  int
+}
+
+// rootSchemaCounts is the result of countSchemaMatches for one whisper root.
+type rootSchemaCounts struct {
+	Root    string
+	Counts  []schemaCount
+	NoMatch int
+}
+
+// countSchemaMatches assigns every metric under root to its first matching schema and returns
+// the per-schema counts in schema order, plus the number of metrics no schema matched. timing
+// may be nil.
+func countSchemaMatches(root string, schemas []Schema, walk walkOptions, timing *runTiming) ([]schemaCount, int, error) {
+	start := time.Now()
+	files, err := findWhisperFiles(root, walk)
+	timing.since(phaseWalk, start)
+	if err != nil {
+		return nil, 0, err
+	}
+	timing.addFiles(len(files))
+	defer timing.since(phaseCompare, time.Now())
+
+	counts := make([]schemaCount, len(schemas))
+	for i := range schemas {
+		counts[i].Schema = &schemas[i]
+	}
+	noMatch := 0
+	for _, f := range files {
+		matched := matchSchema(schemas, walk.metricName(root, f))
+		if matched == nil {
+			noMatch++
+			continue
+		}
+		for i := range counts {
+			if counts[i].Schema == matched {
+				counts[i].Count++
+				break
+			}
+		}
+	}
+	return counts, noMatch, nil
+}
+
+// schemaMembers returns the metrics under root whose first matching schema is the one named
+// name, using the same first-match-wins logic as countSchemaMatches. The name NOMATCH selects
+// the metrics no schema matched.
+func schemaMembers(root string, schemas []Schema, name string, walk walkOptions) ([]string, error) {
+	var target *Schema
+	for i := range schemas {
+		if schemas[i].Name == name {
+			target = &schemas[i]
+			break
+		}
+	}
+	if target == nil && name != "NOMATCH" {
+		return nil, fmt.Errorf("no schema named [%s]", name)
+	}
+
+	files, err := findWhisperFiles(root, walk)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, f := range files {
+		metric := walk.metricName(root, f)
+		if matchSchema(schemas, metric) == target {
+			out = append(out, metric)
+		}
+	}
+	return out, nil
+}
+
+// deadSchemas returns the schemas that matched no metric under any of the roots, candidates
+// for removal from the config.
+func deadSchemas(roots []string, schemas []Schema, walk walkOptions) ([]*Schema, error) {
+	total := make([]int, len(schemas))
+	for _, root := range roots {
+		counts, _, err := countSchemaMatches(root, schemas, walk, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed walking root %s: %v", root, err)
+		}
+		for i, c := range counts {
+			total[i] += c.Count
+		}
+	}
+	var dead []*Schema
+	for i, n := range total {
+		if n == 0 {
+			dead = append(dead, &schemas[i])
+		}
+	}
+	return dead, nil
+}
+
+// printDeadSchemas lists the schemas returned by deadSchemas.
+func printDeadSchemas(dead []*Schema) error {
+	if len(dead) == 0 {
+		fmt.Println("every schema matches at least one metric")
+		return nil
+	}
+	wr := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(wr, "schema\tline\tpattern")
+	for _, s := range dead {
+		_, _ = fmt.Fprintf(wr, "[%s]\t%d\t%s\n", s.Name, s.LineNo, s.PatternRaw)
+	}
+	return wr.Flush()
+}
+
+// printSchemaCounts renders per-root schema counts as a single table. With more than one
+// root every row is prefixed with the root it was counted under. showComments adds the comments
+// above each schema, such as its owner.
+func printSchemaCounts(results []rootSchemaCounts, style string, showComments bool) error {
+	labeled := len(results) > 1
+	tw := newTableWriter(os.Stdout, style)
+	header := []string{"schema", "line", "pattern", "count"}
+	if labeled {
+		header = append([]string{"source"}, header...)
+	}
+	if showComments {
+		header = append(header, "comments")
+	}
+	tw.header(header...)
+	for _, res := range results {
+		var prefix []string
+		if labeled {
+			prefix = []string{res.Root}
+		}
+		for _, c := range res.Counts {
+			row := append(prefix, "["+c.Schema.Name+"]", strconv.Itoa(c.Schema.LineNo), c.Schema.PatternRaw, strconv.Itoa(c.Count))
+			if showComments {
+				row = append(row, strings.Join(c.Schema.Comments, "; "))
+			}
+			tw.row(row...)
+		}
+		row := append(prefix, "NOMATCH", "-", "-", strconv.Itoa(res.NoMatch))
+		if showComments {
+			row = append(row, "-")
+		}
+		tw.row(row...)
+	}
+	return tw.flush()
+}
+
+// countThresholdViolations returns a message for every schema matching more than maxCount
+// metrics (when maxCount > 0) or, with failOnZero, matching none at all.
+func countThresholdViolations(counts []schemaCount, maxCount int, failOnZero bool) []string {
+	var out []string
+	for _, c := range counts {
+		if maxCount > 0 && c.Count > maxCount {
+			out = append(out, fmt.Sprintf("schema [%s] (line %d) matched %d metrics, more than --max-count %d", c.Schema.Name, c.Schema.LineNo, c.Count, maxCount))
+		}
+		if failOnZero && c.Count == 0 {
+			out = append(out, fmt.Sprintf("schema [%s] (line %d) matched no metrics", c.Schema.Name, c.Schema.LineNo))
+		}
+	}
+	return out
+}
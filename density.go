@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// fileDensity is the share of slots of a file's finest archive that hold a point.
+type fileDensity struct {
+	Metric  string  `json:"metric"`
+	Path    string  `json:"path"`
+	Points  int     `json:"points"`
+	Slots   int     `json:"slots"`
+	Density float64 `json:"density"` // percent
+}
+
+// parsePercent parses a threshold such as "50%" or "50" into a percentage between 0 and 100.
+func parsePercent(s string) (float64, error) {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid percentage %q", s)
+	}
+	if v < 0 || v > 100 {
+		return 0, fmt.Errorf("invalid percentage %q: must be between 0 and 100", s)
+	}
+	return v, nil
+}
+
+// finestArchiveDensity counts the non-null points in the finest archive of the whisper file at
+// path within its retention window ending at now.
+func finestArchiveDensity(path string, now int) (points, slots int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to close file %s %v\n", path, err)
+		}
+	}()
+
+	header, err := readWhisperHeader(f)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(header.Archives) == 0 {
+		return 0, 0, fmt.Errorf("no archives")
+	}
+	a := header.Archives[0]
+	stored, err := readArchivePoints(f, a, now)
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(stored), a.Points, nil
+}
+
+// sparseFiles returns the files under root whose finest archive is filled less than below
+// percent. Files that can't be read are reported on stderr and skipped.
+func sparseFiles(root string, below float64, now int, walk walkOptions) ([]fileDensity, error) {
+	files, err := findWhisperFiles(root, walk)
+	if err != nil {
+		return nil, err
+	}
+	var out []fileDensity
+	for _, f := range files {
+		points, slots, err := finestArchiveDensity(f, now)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipping %s: %v\n", f, err)
+			continue
+		}
+		d := fileDensity{Metric: walk.metricName(root, f), Path: f, Points: points, Slots: slots, Density: percent(points, slots)}
+		if d.Density < below {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+// printDensities renders the result of sparseFiles as a table or, with format json, as a JSON
+// array.
+func printDensities(densities []fileDensity, format string) error {
+	if format == "json" {
+		if densities == nil {
+			densities = []fileDensity{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(densities)
+	}
+	wr := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(wr, "metric\tpoints\tslots\tdensity")
+	for _, d := range densities {
+		_, _ = fmt.Fprintf(wr, "%s\t%d\t%d\t%.1f%%\n", d.Metric, d.Points, d.Slots, d.Density)
+	}
+	return wr.Flush()
+}
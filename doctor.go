@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// doctorCheck is one line of the --doctor checklist.
+type doctorCheck struct {
+	Name   string
+	OK     bool
+	Detail string
+}
+
+// runDoctor checks the setup the other modes rely on: that the schemas and aggregation files
+// (when given) parse, that root (when given) is a readable directory containing whisper files,
+// that a sample of them has a valid whisper header, and that the schemas cover at least some
+// of the metrics. Checks that depend on a failed one are left out.
+func runDoctor(schemasPath, aggregationPath, root string, strict bool, walk walkOptions) []doctorCheck {
+	var checks []doctorCheck
+	add := func(name string, err error, ok string) bool {
+		c := doctorCheck{Name: name, OK: err == nil, Detail: ok}
+		if err != nil {
+			c.Detail = err.Error()
+		}
+		checks = append(checks, c)
+		return err == nil
+	}
+
+	var schemas []Schema
+	if schemasPath != "" {
+		var err error
+		schemas, err = parseStorageSchemas(schemasPath, strict)
+		if err == nil && len(schemas) == 0 {
+			err = errors.New("no schemas defined")
+		}
+		add("schemas "+schemasPath, err, fmt.Sprintf("%d schemas parsed", len(schemas)))
+	}
+	if aggregationPath != "" {
+		rules, err := parseStorageAggregation(aggregationPath, strict)
+		ok := fmt.Sprintf("%d aggregation rules parsed", len(rules))
+		if err == nil && !hasCatchAll(rules) {
+			ok += ", " + missingCatchAllWarning
+		}
+		add("aggregation "+aggregationPath, err, ok)
+	}
+	if root == "" {
+		return checks
+	}
+
+	st, err := os.Stat(root)
+	if err == nil && !st.IsDir() {
+		err = fmt.Errorf("not a directory")
+	}
+	if !add("root "+root, err, "directory exists") {
+		return checks
+	}
+	files, err := findWhisperFiles(root, walk)
+	if err == nil && len(files) == 0 {
+		err = fmt.Errorf("no %s files found", defaultExtension)
+	}
+	if !add("whisper files", err, fmt.Sprintf("%d found", len(files))) {
+		return checks
+	}
+
+	sample := files[0]
+	if !add("read "+sample, readable(sample), "readable") {
+		return checks
+	}
+	ok := "valid whisper header"
+	info, err := readFileInfo(sample)
+	if err != nil {
+		err = fmt.Errorf("not a whisper file: %v", err)
+	} else {
+		ok += ", retentions " + formatRetentionList(info.Specs)
+	}
+	if !add("header of "+sample, err, ok) {
+		return checks
+	}
+	if schemas != nil {
+		// a few unmatched metrics are for --check-retention to report, none matching at all
+		// points at the wrong root or schemas file
+		matched := 0
+		for _, f := range files {
+			if matchSchema(schemas, walk.metricName(root, f)) != nil {
+				matched++
+			}
+		}
+		err = nil
+		if matched == 0 {
+			err = fmt.Errorf("none of the %d metrics matches a schema", len(files))
+		}
+		add("schema coverage", err, fmt.Sprintf("%d of %d metrics match a schema", matched, len(files)))
+	}
+	return checks
+}
+
+// readable reports why the file at path can't be opened for reading, if it can't.
+func readable(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// printDoctor writes checks as a checklist and reports whether all of them passed.
+func printDoctor(w io.Writer, checks []doctorCheck) bool {
+	passed := true
+	for _, c := range checks {
+		mark := "ok  "
+		if !c.OK {
+			mark = "FAIL"
+			passed = false
+		}
+		_, _ = fmt.Fprintf(w, "[%s] %s: %s\n", mark, c.Name, c.Detail)
+	}
+	return passed
+}
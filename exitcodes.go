@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// Exit codes, so CI jobs can tell findings apart from broken invocations:
+//
+//	0  everything checked out
+//	1  mismatches or other findings (only with --exit-on-mismatch, the default)
+//	2  usage error: bad flags, arguments or flag values
+//	3  I/O or parse error: unreadable files or trees, invalid config files
+const (
+	exitOK       = 0
+	exitMismatch = 1
+	exitUsage    = 2
+	exitError    = 3
+)
+
+// usageFatal logs its arguments like log.Fatal and exits with exitUsage.
+func usageFatal(v ...any) {
+	_ = log.Output(2, fmt.Sprint(v...))
+	os.Exit(exitUsage)
+}
+
+// usageFatalf logs its arguments like log.Fatalf and exits with exitUsage.
+func usageFatalf(format string, v ...any) {
+	_ = log.Output(2, fmt.Sprintf(format, v...))
+	os.Exit(exitUsage)
+}
+
+// fatal logs its arguments like log.Fatal and exits with exitError.
+func fatal(v ...any) {
+	_ = log.Output(2, fmt.Sprint(v...))
+	os.Exit(exitError)
+}
+
+// fatalf logs its arguments like log.Fatalf and exits with exitError.
+func fatalf(format string, v ...any) {
+	_ = log.Output(2, fmt.Sprintf(format, v...))
+	os.Exit(exitError)
+}
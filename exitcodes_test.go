@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	whisper "github.com/go-graphite/go-whisper"
+)
+
+// runMainEnv makes the test binary run main instead of the tests, see runTool.
+const runMainEnv = "WHISPER_TOOLS_TEST_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) == "1" {
+		main()
+		os.Exit(exitOK)
+	}
+	os.Exit(m.Run())
+}
+
+// runTool runs the tool with args in a fresh process and returns its exit code. It runs in an
+// empty directory with an empty $HOME, so no .whisper-tools.conf is picked up.
+func runTool(t *testing.T, args ...string) int {
+	t.Helper()
+	return runToolIn(t, t.TempDir(), args...)
+}
+
+// runToolIn is runTool with dir as working directory and $HOME.
+func runToolIn(t *testing.T, dir string, args ...string) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), runMainEnv+"=1", "HOME="+dir)
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatalf("running %v: %v", args, err)
+	}
+	t.Logf("%v:\n%s", args, out)
+	return exitOK
+}
+
+// writeTestWhisper creates a whisper file at path with the given retentions, e.g. "1m:1d",
+// creating its directory as needed.
+func writeTestWhisper(t testing.TB, path, retentions string, method whisper.AggregationMethod, xff float32) {
+	t.Helper()
+	specs, err := parseRetentionList(retentions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var archives whisper.Retentions
+	for _, spec := range specs {
+		r := whisper.NewRetention(spec.SecondsPerPoint, spec.points())
+		archives = append(archives, &r)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	w, err := whisper.Create(path, archives, method, xff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestFile writes data to a file named name in a temporary directory and returns its path.
+func writeTestFile(t testing.TB, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExitCodes(t *testing.T) {
+	root := t.TempDir()
+	writeTestWhisper(t, filepath.Join(root, "servers", "web01", "cpu.wsp"), "1m:1d", whisper.Average, 0.5)
+	mismatchRoot := t.TempDir()
+	writeTestWhisper(t, filepath.Join(mismatchRoot, "servers", "web01", "cpu.wsp"), "1m:7d", whisper.Average, 0.5)
+	brokenRoot := t.TempDir()
+	if err := os.WriteFile(filepath.Join(brokenRoot, "broken.wsp"), []byte("not whisper"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	schemas := writeTestFile(t, "storage-schemas.conf", "[servers]\npattern = ^servers\\.\nretentions = 1m:1d\n")
+	unordered := writeTestFile(t, "storage-schemas.conf", "[servers]\npattern = ^servers\\.\nretentions = 1h:1y, 1m:1d\n")
+	badRegex := writeTestFile(t, "storage-schemas.conf", "[servers]\npattern = ^servers(\nretentions = 1m:1d\n")
+	missing := filepath.Join(t.TempDir(), "missing.conf")
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"check ok", []string{"--check-retention", "--schemas=" + schemas, root}, exitOK},
+		{"check mismatch", []string{"--check-retention", "--schemas=" + schemas, mismatchRoot}, exitMismatch},
+		{"check mismatch without exit-on-mismatch", []string{"--check-retention", "--exit-on-mismatch=false", "--schemas=" + schemas, mismatchRoot}, exitOK},
+		{"check without schemas", []string{"--check-retention", root}, exitUsage},
+		{"check bad format", []string{"--check-retention", "--format=yaml", "--schemas=" + schemas, root}, exitUsage},
+		{"check missing schemas file", []string{"--check-retention", "--schemas=" + missing, root}, exitError},
+		{"check bad regex", []string{"--check-retention", "--schemas=" + badRegex, root}, exitError},
+		{"validate ok", []string{"--validate", "--schemas=" + schemas}, exitOK},
+		{"validate finding", []string{"--validate", "--schemas=" + unordered}, exitMismatch},
+		{"validate without config", []string{"--validate"}, exitUsage},
+		{"validate missing file", []string{"--validate", "--schemas=" + missing}, exitError},
+		{"validate bad regex", []string{"--validate", "--schemas=" + badRegex}, exitError},
+		{"validate long line", []string{"--validate", "--max-config-line=10", "--schemas=" + schemas}, exitError},
+		{"validate zero max line", []string{"--validate", "--max-config-line=0", "--schemas=" + schemas}, exitUsage},
+		{"verify ok", []string{"--verify", root}, exitOK},
+		{"verify broken file", []string{"--verify", brokenRoot}, exitMismatch},
+		{"verify missing root", []string{"--verify", filepath.Join(root, "missing")}, exitError},
+		{"version", []string{"--version"}, exitOK},
+		{"version before a mode", []string{"--version", "--validate"}, exitOK},
+		{"unknown flag", []string{"--no-such-flag"}, exitUsage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runTool(t, tt.args...); got != tt.want {
+				t.Errorf("exit code %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
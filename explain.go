@@ -0,0 +1,40 @@
+package main
+
+import "fmt"
+
+// schemaTrial is one schema a metric was tested against while looking for its first match.
+type schemaTrial struct {
+	Schema    string `json:"schema"`
+	LineNo    int    `json:"line"`
+	MatchType string `json:"matchType"`
+	Pattern   string `json:"pattern"`
+	Matched   bool   `json:"matched"`
+}
+
+// describe renders the trial as a single line for --explain.
+func (t schemaTrial) describe() string {
+	verdict := "no match"
+	if t.Matched {
+		verdict = "MATCH"
+	}
+	return fmt.Sprintf("[%s] (line %d) %s %s: %s", t.Schema, t.LineNo, t.MatchType, t.Pattern, verdict)
+}
+
+// explainMatch lists the schemas matchSchema tests metric against, in order, up to and
+// including the first one that matches. Schemas without a pattern are never tested and left
+// out.
+func explainMatch(schemas []Schema, metric string) []schemaTrial {
+	var trace []schemaTrial
+	for i := range schemas {
+		s := &schemas[i]
+		if s.PatternRaw == "" {
+			continue
+		}
+		matched := sectionMatches(s.MatchType, s.PatternRaw, s.Pattern, metric)
+		trace = append(trace, schemaTrial{Schema: s.Name, LineNo: s.LineNo, MatchType: s.MatchType, Pattern: s.PatternRaw, Matched: matched})
+		if matched {
+			break
+		}
+	}
+	return trace
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	whisper "github.com/go-graphite/go-whisper"
+)
+
+// seriesPoint is one interval of a fetched series; Null marks intervals without data.
+type seriesPoint struct {
+	Timestamp int
+	Value     float64
+	Null      bool
+}
+
+// selectArchive mirrors the archive selection of whisper.Fetch: the first (finest) archive
+// whose retention reaches back to from, else the coarsest one.
+func selectArchive(h *whisperHeader, from, now int) int {
+	diff := now - from
+	for i, a := range h.Archives {
+		if a.Retention() >= diff {
+			return i
+		}
+	}
+	return len(h.Archives) - 1
+}
+
+// fetchArchive returns one point per interval of archive a between from and until, both
+// clamped to the archive's retention window and aligned to its resolution like whisper does.
+func fetchArchive(r io.ReaderAt, a archiveHeader, from, until, now int) ([]seriesPoint, error) {
+	if oldest := now - a.Retention(); from < oldest {
+		from = oldest
+	}
+	if until > now {
+		until = now
+	}
+	if from > until {
+		return nil, fmt.Errorf("invalid time interval: from time '%d' is after until time '%d'", from, until)
+	}
+
+	points, err := readArchivePoints(r, a, now)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[int]float64, len(points))
+	for _, p := range points {
+		known[p.Timestamp] = p.Value
+	}
+
+	step := a.SecondsPerPoint
+	start := from - from%step + step
+	end := until - until%step + step
+	out := make([]seriesPoint, 0, (end-start)/step)
+	for ts := start; ts < end; ts += step {
+		v, ok := known[ts]
+		out = append(out, seriesPoint{Timestamp: ts, Value: v, Null: !ok})
+	}
+	return out, nil
+}
+
+// fetchOptions selects what --fetch reads from a file.
+type fetchOptions struct {
+	Archive int // archive index, -1 selects the archive like whisper.Fetch
+	From    int // seconds before now, 0 means the whole retention of the selected archive
+}
+
+// fetchSource describes the archive fetchFile read its points from.
+type fetchSource struct {
+	Archive           int
+	Spec              ArchiveSpec
+	AggregationMethod whisper.AggregationMethod
+}
+
+// producedBy tells how the values of the archive came about: the finest archive stores points
+// as written, coarser ones hold values aggregated with the file's aggregation method.
+func (s fetchSource) producedBy() string {
+	if s.Archive == 0 {
+		return "raw"
+	}
+	return s.AggregationMethod.String()
+}
+
+// fetchFile reads a series from the whisper file at path. It also returns the archive the
+// points were read from.
+func fetchFile(path string, opts fetchOptions, now int) (fetchSource, []seriesPoint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return fetchSource{}, nil, err
+	}
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to close file %s %v\n", path, err)
+		}
+	}()
+
+	header, err := readWhisperHeader(f)
+	if err != nil {
+		return fetchSource{}, nil, err
+	}
+	if len(header.Archives) == 0 {
+		return fetchSource{}, nil, fmt.Errorf("file has no archives")
+	}
+
+	idx := opts.Archive
+	if idx >= len(header.Archives) {
+		return fetchSource{}, nil, fmt.Errorf("archive %d out of range: file has %d archives", idx, len(header.Archives))
+	}
+	from := now - opts.From
+	if idx < 0 {
+		if opts.From == 0 {
+			// whisper-fetch defaults to the last 24 hours
+			from = now - 86400
+		}
+		idx = selectArchive(header, from, now)
+	} else if opts.From == 0 {
+		from = now - header.Archives[idx].Retention()
+	}
+
+	a := header.Archives[idx]
+	src := fetchSource{
+		Archive:           idx,
+		Spec:              ArchiveSpec{SecondsPerPoint: a.SecondsPerPoint, RetentionSecs: a.Retention()},
+		AggregationMethod: header.AggregationMethod,
+	}
+	points, err := fetchArchive(f, a, from, now, now)
+	return src, points, err
+}
+
+// printSeries prints one "timestamp<TAB>value" line per point. Timestamps are rendered with
+// formatTimestamp in loc. Null points are rendered according to nullAs: "skip" omits the line,
+// "empty" leaves the value empty, "nan" prints NaN and anything else is printed literally, so
+// the default "None" matches whisper-fetch. With annotate, every line gets two more columns: the
+// archive index and how its values were produced (see fetchSource.producedBy).
+func printSeries(points []seriesPoint, loc *time.Location, nullAs string, src fetchSource, annotate bool) {
+	suffix := ""
+	if annotate {
+		suffix = fmt.Sprintf("\t%d\t%s", src.Archive, src.producedBy())
+	}
+	null := nullAs
+	switch nullAs {
+	case "empty":
+		null = ""
+	case "nan":
+		null = "NaN"
+	}
+	for _, p := range points {
+		v := null
+		if !p.Null {
+			v = strconv.FormatFloat(p.Value, 'f', -1, 64)
+		} else if nullAs == "skip" {
+			continue
+		}
+		fmt.Printf("%s\t%s%s\n", formatTimestamp(p.Timestamp, loc), v, suffix)
+	}
+}
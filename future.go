@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// futureFile is a whisper file holding a point timestamped after now, typically written by a
+// collector with a skewed clock.
+type futureFile struct {
+	Metric string `json:"metric"`
+	Path   string `json:"path"`
+	Newest int    `json:"newest"` // unix timestamp of the newest point
+	Ahead  int    `json:"ahead"`  // seconds the newest point is ahead of now
+}
+
+// newestPoint returns the largest timestamp stored in any archive of the whisper file at path,
+// including slots outside the retention window, or 0 if no point was ever written.
+func newestPoint(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to close file %s %v\n", path, err)
+		}
+	}()
+
+	header, err := readWhisperHeader(f)
+	if err != nil {
+		return 0, err
+	}
+	newest := 0
+	for _, a := range header.Archives {
+		slots, err := readArchiveSlots(f, a)
+		if err != nil {
+			return 0, err
+		}
+		for _, p := range slots {
+			newest = max(newest, p.Timestamp)
+		}
+	}
+	return newest, nil
+}
+
+// futureFiles returns the files under root whose newest point is more than tolerance seconds
+// after now, most skewed first. Files that can't be read are reported on stderr and skipped.
+func futureFiles(root string, tolerance, now int, walk walkOptions) ([]futureFile, error) {
+	files, err := findWhisperFiles(root, walk)
+	if err != nil {
+		return nil, err
+	}
+	var out []futureFile
+	for _, f := range files {
+		newest, err := newestPoint(f)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipping %s: %v\n", f, err)
+			continue
+		}
+		if newest > now+tolerance {
+			out = append(out, futureFile{Metric: walk.metricName(root, f), Path: f, Newest: newest, Ahead: newest - now})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Ahead > out[j].Ahead })
+	return out, nil
+}
+
+// printFutureFiles renders the result of futureFiles as a table or, with format json, as a JSON
+// array. Timestamps are rendered in loc, see formatTimestamp.
+func printFutureFiles(files []futureFile, format string, loc *time.Location) error {
+	if format == "json" {
+		if files == nil {
+			files = []futureFile{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(files)
+	}
+	wr := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(wr, "metric\tnewest\tahead")
+	for _, f := range files {
+		_, _ = fmt.Fprintf(wr, "%s\t%s\t%s\n", f.Metric, formatTimestamp(f.Newest, loc), toHuman(f.Ahead))
+	}
+	return wr.Flush()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// dataGap is a run of consecutive null points in an archive.
+type dataGap struct {
+	Start    int `json:"start"`    // timestamp of the first null point
+	End      int `json:"end"`      // timestamp of the first point after the gap
+	Duration int `json:"duration"` // seconds
+	Points   int `json:"points"`
+}
+
+// findGaps returns the runs of null points in points, which are spaced step seconds apart,
+// largest first and in time order among equally long ones. Nulls before the first point with
+// data are left out: they usually predate the file rather than mark an outage. A run reaching
+// the end of points is an outage still going on.
+func findGaps(points []seriesPoint, step int) []dataGap {
+	var gaps []dataGap
+	seenData := false
+	run := 0
+	// closeRun records the current run as ending at end, the timestamp following it
+	closeRun := func(end int) {
+		if run > 0 {
+			gaps = append(gaps, dataGap{Start: end - run*step, End: end, Duration: run * step, Points: run})
+			run = 0
+		}
+	}
+	for _, p := range points {
+		if !p.Null {
+			closeRun(p.Timestamp)
+			seenData = true
+		} else if seenData {
+			run++
+		}
+	}
+	if len(points) > 0 {
+		closeRun(points[len(points)-1].Timestamp + step)
+	}
+	sort.SliceStable(gaps, func(i, j int) bool { return gaps[i].Duration > gaps[j].Duration })
+	return gaps
+}
+
+// printGaps renders the first top gaps (all with top <= 0) as a table or, with format json, as
+// a JSON array. Timestamps are rendered in loc, see formatTimestamp.
+func printGaps(gaps []dataGap, top int, format string, loc *time.Location) error {
+	if top > 0 && len(gaps) > top {
+		gaps = gaps[:top]
+	}
+	if format == "json" {
+		if gaps == nil {
+			gaps = []dataGap{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(gaps)
+	}
+	wr := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(wr, "start\tend\tduration\tpoints")
+	for _, g := range gaps {
+		_, _ = fmt.Fprintf(wr, "%s\t%s\t%s\t%d\n", formatTimestamp(g.Start, loc), formatTimestamp(g.End, loc), toHuman(g.Duration), g.Points)
+	}
+	return wr.Flush()
+}
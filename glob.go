@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// globSegmentRegexp translates one segment of a Graphite glob into an anchored regex: * matches
+// any run of characters, ? a single character, [...] a character class and {a,b} any of the
+// alternatives.
+func globSegmentRegexp(seg string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	inBraces := false
+	for i := 0; i < len(seg); i++ {
+		c := seg[i]
+		switch {
+		case c == '*':
+			b.WriteString(".*")
+		case c == '?':
+			b.WriteString(".")
+		case c == '[':
+			end := strings.IndexByte(seg[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated [ in %q", seg)
+			}
+			b.WriteString(seg[i : i+end+1])
+			i += end
+		case c == '{' && !inBraces:
+			b.WriteString("(?:")
+			inBraces = true
+		case c == '}' && inBraces:
+			b.WriteString(")")
+			inBraces = false
+		case c == ',' && inBraces:
+			b.WriteString("|")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if inBraces {
+		return nil, fmt.Errorf("unterminated { in %q", seg)
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// expandGraphiteGlob returns the .wsp files under root whose metric name matches the dotted
+// Graphite glob query, e.g. servers.web0?.{cpu,mem}. Every segment is matched against one level
+// of the tree, so only directories the query can reach are read. The paths are sorted.
+func expandGraphiteGlob(root, query string) ([]string, error) {
+	segments := strings.Split(query, ".")
+	res := make([]*regexp.Regexp, len(segments))
+	for i, seg := range segments {
+		re, err := globSegmentRegexp(seg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query %q: %v", query, err)
+		}
+		res[i] = re
+	}
+
+	dirs := []string{root}
+	for i, re := range res {
+		last := i == len(res)-1
+		var next []string
+		for _, dir := range dirs {
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				name := e.Name()
+				if last {
+					if e.IsDir() || !strings.HasSuffix(strings.ToLower(name), ".wsp") {
+						continue
+					}
+					name = name[:len(name)-len(".wsp")]
+				} else if !e.IsDir() {
+					continue
+				}
+				if re.MatchString(name) {
+					next = append(next, filepath.Join(dir, e.Name()))
+				}
+			}
+		}
+		dirs = next
+	}
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+// metricList is a set of metric names and Graphite globs, e.g. loaded with --metrics-file.
+type metricList struct {
+	names map[string]bool
+	globs [][]*regexp.Regexp // one regex per dotted segment
+}
+
+// readMetricList reads a metric list with one metric name or Graphite glob per line. Blank
+// lines and lines starting with # are ignored.
+func readMetricList(path string) (*metricList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to close file %s %v\n", path, err)
+		}
+	}()
+
+	list := &metricList{names: map[string]bool{}}
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.ContainsAny(line, "*?[{") {
+			list.names[line] = true
+			continue
+		}
+		var glob []*regexp.Regexp
+		for seg := range strings.SplitSeq(line, ".") {
+			re, err := globSegmentRegexp(seg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid glob %q at line %d: %v", line, lineNo, err)
+			}
+			glob = append(glob, re)
+		}
+		list.globs = append(list.globs, glob)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// contains reports whether metric is one of the listed names or matches one of the globs
+// segment by segment.
+func (l *metricList) contains(metric string) bool {
+	if l.names[metric] {
+		return true
+	}
+	segments := strings.Split(metric, ".")
+	for _, glob := range l.globs {
+		if len(glob) != len(segments) {
+			continue
+		}
+		matched := true
+		for i, re := range glob {
+			if !re.MatchString(segments[i]) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestExpandGraphiteGlob(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{
+		"servers/web01/cpu.wsp",
+		"servers/web01/mem.wsp",
+		"servers/web01/disk.wsp",
+		"servers/web02/cpu.wsp",
+		"servers/web10/cpu.wsp",
+		"servers/db01/cpu.wsp",
+		"servers/web01/cpu.txt",
+		"servers/cpu.wsp/keep.wsp", // a directory named like a file
+		"stats/count.wsp",
+	} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"servers.web01.cpu", []string{"servers/web01/cpu.wsp"}},
+		{"servers.*.cpu", []string{"servers/db01/cpu.wsp", "servers/web01/cpu.wsp", "servers/web02/cpu.wsp", "servers/web10/cpu.wsp"}},
+		{"servers.web0?.cpu", []string{"servers/web01/cpu.wsp", "servers/web02/cpu.wsp"}},
+		{"servers.web[01]*.cpu", []string{"servers/web01/cpu.wsp", "servers/web02/cpu.wsp", "servers/web10/cpu.wsp"}},
+		{"servers.web01.{cpu,mem}", []string{"servers/web01/cpu.wsp", "servers/web01/mem.wsp"}},
+		{"*.*", []string{"stats/count.wsp"}},
+		{"servers.cpu", nil},
+		{"nothing.*", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := expandGraphiteGlob(root, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want []string
+			for _, w := range tt.want {
+				want = append(want, filepath.Join(root, filepath.FromSlash(w)))
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("expandGraphiteGlob(%q) = %v, want %v", tt.query, got, want)
+			}
+		})
+	}
+}
+
+func TestExpandGraphiteGlobErrors(t *testing.T) {
+	root := t.TempDir()
+	for _, query := range []string{"servers.[ab", "servers.{a,b"} {
+		if _, err := expandGraphiteGlob(root, query); err == nil {
+			t.Errorf("expandGraphiteGlob(%q) succeeded, want error", query)
+		}
+	}
+	if _, err := expandGraphiteGlob(filepath.Join(root, "missing"), "a"); err == nil {
+		t.Error("missing root succeeded, want error")
+	}
+}
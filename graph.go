@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// schemaSample derives a metric name s matches from its pattern, for guessing which earlier
+// schemas may shadow it: literal and prefix patterns are samples themselves, for regexes the
+// literal prefix is tried, on its own and extended. ok is false when no sample could be found,
+// e.g. for patterns starting with a character class.
+func schemaSample(s *Schema) (sample string, ok bool) {
+	if s.MatchType != matchRegex {
+		return s.PatternRaw, true
+	}
+	if s.Pattern == nil {
+		return "", false
+	}
+	prefix, _ := s.Pattern.LiteralPrefix()
+	if prefix == "" {
+		return "", false
+	}
+	for _, candidate := range []string{prefix, prefix + "x"} {
+		if s.Pattern.MatchString(candidate) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// schemaShadows lists, for every schema index, the later schemas it may shadow: schemas whose
+// sample metric (see schemaSample) it matches first, or all later ones for a catch-all. This is
+// a heuristic, a shadowed schema may still match metrics the earlier one doesn't.
+func schemaShadows(schemas []Schema) map[int][]int {
+	shadows := map[int][]int{}
+	for j := range schemas {
+		later := &schemas[j]
+		if later.PatternRaw == "" {
+			continue
+		}
+		sample, ok := schemaSample(later)
+		for i := range j {
+			earlier := &schemas[i]
+			if earlier.PatternRaw == "" {
+				continue
+			}
+			if matchesAllProbes(earlier.MatchType, earlier.PatternRaw, earlier.Pattern) || (ok && sectionMatches(earlier.MatchType, earlier.PatternRaw, earlier.Pattern, sample)) {
+				shadows[i] = append(shadows[i], j)
+			}
+		}
+	}
+	return shadows
+}
+
+// dotEscape escapes s for use inside a double-quoted Graphviz string.
+func dotEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// writeSchemaGraph writes schemas as a Graphviz DOT digraph: one node per section from top to
+// bottom in file order, linked by gray edges, and red "may shadow" edges from every schema to
+// the later ones it likely takes metrics from (see schemaShadows). Render it with
+// e.g. dot -Tsvg.
+func writeSchemaGraph(w io.Writer, schemas []Schema) error {
+	var b strings.Builder
+	b.WriteString("digraph schemas {\n")
+	b.WriteString("  rankdir=TB;\n")
+	b.WriteString("  node [shape=box, fontname=monospace];\n")
+	for i, s := range schemas {
+		label := fmt.Sprintf("[%s] line %d\n%s %s\n%s", s.Name, s.LineNo, s.MatchType, s.PatternRaw, formatRetentionList(s.Retentions))
+		attrs := ""
+		if s.PatternRaw == "" {
+			attrs = ", style=dashed"
+		}
+		fmt.Fprintf(&b, "  s%d [label=\"%s\"%s];\n", i, dotEscape(label), attrs)
+	}
+	for i := 1; i < len(schemas); i++ {
+		fmt.Fprintf(&b, "  s%d -> s%d [color=gray];\n", i-1, i)
+	}
+	shadows := schemaShadows(schemas)
+	for i := range schemas {
+		for _, j := range shadows[i] {
+			fmt.Fprintf(&b, "  s%d -> s%d [color=red, fontcolor=red, label=\"may shadow\", constraint=false];\n", i, j)
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
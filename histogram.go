@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// histogramBucket is one distinct value and the number of files sharing it.
+type histogramBucket struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// sortedBuckets turns a tally into buckets ordered by count (descending), then key.
+func sortedBuckets(tally map[string]int) []histogramBucket {
+	out := make([]histogramBucket, 0, len(tally))
+	for k, n := range tally {
+		out = append(out, histogramBucket{Key: k, Count: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+// retentionHistogram reads the actual retentions of every .wsp file under root and tallies
+// the distinct retention configurations, canonicalized with formatRetentionList. Files that
+// can't be read are reported on stderr and counted separately.
+func retentionHistogram(root string, walk walkOptions) ([]histogramBucket, int, error) {
+	files, err := findWhisperFiles(root, walk)
+	if err != nil {
+		return nil, 0, err
+	}
+	tally := map[string]int{}
+	unreadable := 0
+	for _, f := range files {
+		specs, err := readFileSpecs(f)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", f, err)
+			unreadable++
+			continue
+		}
+		tally[formatRetentionList(specs)]++
+	}
+	return sortedBuckets(tally), unreadable, nil
+}
+
+// aggregationHistogram tallies the aggregation method and xFilesFactor combinations of every
+// .wsp file under root. Keys are "method\txff" so they render as two table columns.
+func aggregationHistogram(root string, walk walkOptions) ([]histogramBucket, int, error) {
+	files, err := findWhisperFiles(root, walk)
+	if err != nil {
+		return nil, 0, err
+	}
+	tally := map[string]int{}
+	unreadable := 0
+	for _, f := range files {
+		attrs, err := readFileAttrs(f)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", f, err)
+			unreadable++
+			continue
+		}
+		tally[fmt.Sprintf("%s\t%g", attrs.AggregationMethod, attrs.XFilesFactor)]++
+	}
+	return sortedBuckets(tally), unreadable, nil
+}
+
+// printHistogram renders buckets as a frequency table with a share of the total, where the
+// total includes unreadable files.
+func printHistogram(column string, buckets []histogramBucket, unreadable int) error {
+	total := unreadable
+	for _, b := range buckets {
+		total += b.Count
+	}
+	wr := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintf(wr, "count\tpercent\t%s\n", column)
+	for _, b := range buckets {
+		_, _ = fmt.Fprintf(wr, "%d\t%.1f%%\t%s\n", b.Count, percent(b.Count, total), b.Key)
+	}
+	if unreadable > 0 {
+		_, _ = fmt.Fprintf(wr, "%d\t%.1f%%\tunreadable\n", unreadable, percent(unreadable, total))
+	}
+	if err := wr.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("\n%d distinct, %d files\n", len(buckets), total)
+	return nil
+}
+
+// percent returns n as a percentage of total, 0 when total is 0.
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
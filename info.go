@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// fileInfo is what info shows about a single whisper file: its attributes, with Specs holding
+// one entry per archive, finest first.
+type fileInfo struct {
+	Path string
+	fileAttrs
+}
+
+// readFileInfo reads the header of the whisper file at path, read-only, see readFileAttrs.
+func readFileInfo(path string) (*fileInfo, error) {
+	attrs, err := readFileAttrs(path)
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{Path: path, fileAttrs: attrs}, nil
+}
+
+// printFileInfo renders info the way info prints it: the aggregation settings, the size and
+// modification time from st when it isn't nil, and a table of the archives drawn in
+// tableStyle. With asPoints retentions are listed as resolution:points. The modification time
+// is rendered in loc, or the local zone without one.
+func printFileInfo(w io.Writer, info *fileInfo, st os.FileInfo, loc *time.Location, asPoints bool, tableStyle string) error {
+	_, _ = fmt.Fprintf(w, "File: %s\n", info.Path)
+	_, _ = fmt.Fprintf(w, "Aggregation: %s\n", info.AggregationMethod)
+	_, _ = fmt.Fprintf(w, "xFilesFactor: %g\n", info.XFilesFactor)
+	if st != nil {
+		_, _ = fmt.Fprintf(w, "Size: %d bytes (%s)\n", st.Size(), humanBytes(st.Size()))
+		if loc == nil {
+			loc = time.Local
+		}
+		_, _ = fmt.Fprintf(w, "Modified: %s\n", formatTimestamp(int(st.ModTime().Unix()), loc))
+	}
+	_, _ = fmt.Fprintf(w, "Finest resolution: %s\n", toHuman(finestResolution(info.Specs)))
+	_, _ = fmt.Fprintf(w, "Max retention: %s\n", toHuman(maxRetention(info.Specs)))
+	if asPoints {
+		_, _ = fmt.Fprintf(w, "Retentions: %s\n", formatRetentionListAsPoints(info.Specs))
+	} else {
+		_, _ = fmt.Fprintf(w, "Retentions: %s\n", formatRetentionList(info.Specs))
+	}
+	_, _ = fmt.Fprintln(w)
+
+	tw := newTableWriter(w, tableStyle)
+	tw.header("archive", "seconds/point", "#points", "retention", "max age (sec)")
+	for i, spec := range info.Specs {
+		tw.row(
+			strconv.Itoa(i),
+			strconv.Itoa(spec.SecondsPerPoint),
+			strconv.Itoa(spec.points()),
+			toHuman(spec.RetentionSecs),
+			strconv.Itoa(spec.RetentionSecs),
+		)
+	}
+	return tw.flush()
+}
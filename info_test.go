@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	whisper "github.com/go-graphite/go-whisper"
+)
+
+func TestReadFileInfo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cpu.wsp")
+	writeTestWhisper(t, path, "10s:6h,1m:7d,1h:1y", whisper.Max, 0.25)
+
+	info, err := readFileInfo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &fileInfo{
+		Path: path,
+		fileAttrs: fileAttrs{
+			Specs: []ArchiveSpec{
+				{SecondsPerPoint: 10, RetentionSecs: 6 * 3600},
+				{SecondsPerPoint: 60, RetentionSecs: 7 * 86400},
+				{SecondsPerPoint: 3600, RetentionSecs: 31536000},
+			},
+			AggregationMethod: whisper.Max,
+			XFilesFactor:      0.25,
+		},
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("readFileInfo = %+v, want %+v", info, want)
+	}
+
+	// only the header is read, no write permission needed
+	if err := os.Chmod(path, 0o444); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readFileInfo(path); err != nil {
+		t.Errorf("read-only file: %v", err)
+	}
+	if _, err := readFileInfo(filepath.Join(t.TempDir(), "missing.wsp")); err == nil {
+		t.Error("missing file: want error")
+	}
+}
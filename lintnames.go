@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+)
+
+// metricNameProblems returns the reasons a derived metric name is likely to be rejected or
+// mangled by Graphite and downstream tools. An empty result means the name looks sane.
+func metricNameProblems(metric string) []string {
+	var problems []string
+	var hasSpace, hasControl, hasNonASCII bool
+	for _, r := range metric {
+		switch {
+		case unicode.IsSpace(r):
+			hasSpace = true
+		case unicode.IsControl(r):
+			hasControl = true
+		case r > unicode.MaxASCII:
+			hasNonASCII = true
+		}
+	}
+	if hasSpace {
+		problems = append(problems, "whitespace")
+	}
+	if hasControl {
+		problems = append(problems, "control character")
+	}
+	if hasNonASCII {
+		problems = append(problems, "non-ASCII")
+	}
+	if strings.Contains(metric, "..") {
+		problems = append(problems, "consecutive dots")
+	}
+	if strings.HasPrefix(metric, ".") {
+		problems = append(problems, "leading dot")
+	}
+	if strings.HasSuffix(metric, ".") {
+		problems = append(problems, "trailing dot")
+	}
+	return problems
+}
+
+// sanitizeMetricName suggests a replacement for a problematic metric name:
+// whitespace, control and non-ASCII characters become "_", runs of dots collapse
+// into one and leading/trailing dots are dropped.
+func sanitizeMetricName(metric string) string {
+	var b strings.Builder
+	for _, r := range metric {
+		if unicode.IsSpace(r) || unicode.IsControl(r) || r > unicode.MaxASCII {
+			b.WriteByte('_')
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return normalizeMetricName(b.String())
+}
+
+// lintMetricNames reports every .wsp file under root whose derived metric name has problems.
+// When suggest is set a suggested rename is printed as well. It never modifies the tree and
+// returns true if at least one problematic name was found.
+func lintMetricNames(root string, suggest bool, walk walkOptions) (bool, error) {
+	files, err := findWhisperFiles(root, walk)
+	if err != nil {
+		return false, err
+	}
+
+	wr := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+	header := "metric\tproblems"
+	if suggest {
+		header += "\tsuggested"
+	}
+	_, _ = fmt.Fprintln(wr, header)
+
+	// always lint the raw name, normalization would hide the problems reported here
+	walk.NoNormalize = true
+	found := false
+	for _, f := range files {
+		metric := walk.metricName(root, f)
+		problems := metricNameProblems(metric)
+		if len(problems) == 0 {
+			continue
+		}
+		found = true
+		// quote the name so control characters and trailing spaces stay visible
+		line := fmt.Sprintf("%q\t%s", metric, strings.Join(problems, ", "))
+		if suggest {
+			line += "\t" + sanitizeMetricName(metric)
+		}
+		_, _ = fmt.Fprintln(wr, line)
+	}
+	return found, wr.Flush()
+}
@@ -1,16 +1,25 @@
 package main
 
 import (
-	"bufio"
+	"archive/tar"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"io/fs"
+	"math"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
-	"text/tabwriter"
+	"syscall"
+	"text/template"
+	"time"
 
 	whisper "github.com/go-graphite/go-whisper"
 )
@@ -23,9 +32,17 @@ type ArchiveSpec struct {
 type Schema struct {
 	Name       string
 	PatternRaw string
+	// PatternFlags holds the optional patternFlags key (e.g. "i", "is"), applied as a
+	// (?flags) prefix when compiling Pattern so PatternRaw stays as written.
+	PatternFlags string
+	// MatchType is one of matchRegex, matchPrefix or matchLiteral; Pattern is only compiled
+	// for regex.
+	MatchType  string
 	Pattern    *regexp.Regexp
 	Retentions []ArchiveSpec
 	LineNo     int // ordering preserved; earlier lines have smaller LineNo
+	// Comments are the comment lines directly above the section, e.g. "owner: team-x".
+	Comments []string
 }
 
 // toHuman converts seconds into a single-unit short representation used by storage-schemas,
@@ -54,7 +71,16 @@ func toHuman(seconds int) string {
 	return fmt.Sprintf("%ds", seconds)
 }
 
-// fromHuman parses strings like "10s", "5m", "2h", "7d", "1y" into seconds.
+// formatTimestamp renders a unix timestamp for output: as plain epoch seconds when loc is nil,
+// otherwise as RFC 3339 in loc.
+func formatTimestamp(ts int, loc *time.Location) string {
+	if loc == nil {
+		return strconv.Itoa(ts)
+	}
+	return time.Unix(int64(ts), 0).In(loc).Format(time.RFC3339)
+}
+
+// fromHuman parses strings like "10s", "5m", "2h", "7d", "2w", "1y" into seconds.
 // Accepts an optional whitespace trimmed string.
 // Returns -1 on error.
 func fromHuman(s string) (int, error) {
@@ -62,6 +88,10 @@ func fromHuman(s string) (int, error) {
 	if s == "" {
 		return -1, fmt.Errorf("empty duration")
 	}
+	// "100ms" would otherwise be read as a malformed "100m"
+	if strings.HasSuffix(strings.ToLower(s), "ms") {
+		return -1, fmt.Errorf("sub-second duration %q is not supported: whisper resolution is whole seconds", s)
+	}
 	// number at front, last rune is unit
 	n := len(s)
 	unit := s[n-1]
@@ -80,6 +110,8 @@ func fromHuman(s string) (int, error) {
 		return val * 3600, nil
 	case 'd', 'D':
 		return val * 86400, nil
+	case 'w', 'W':
+		return val * 604800, nil
 	case 'y', 'Y':
 		return val * 31536000, nil
 	default:
@@ -92,15 +124,67 @@ func (spec ArchiveSpec) toHuman() string {
 	return fmt.Sprintf("%s:%s", toHuman(spec.SecondsPerPoint), toHuman(spec.RetentionSecs))
 }
 
+// points returns the number of points whisper allocates for the archive: its retention divided
+// by its resolution, rounded down. It is 0 for a spec without a resolution.
+func (spec ArchiveSpec) points() int {
+	if spec.SecondsPerPoint <= 0 {
+		return 0
+	}
+	return spec.RetentionSecs / spec.SecondsPerPoint
+}
+
+// normalized returns spec with its retention rounded down to a whole number of points, the
+// history a file created from it actually keeps.
+func (spec ArchiveSpec) normalized() ArchiveSpec {
+	if spec.SecondsPerPoint <= 0 {
+		return spec
+	}
+	return ArchiveSpec{SecondsPerPoint: spec.SecondsPerPoint, RetentionSecs: spec.points() * spec.SecondsPerPoint}
+}
+
 func formatRetentionList(specs []ArchiveSpec) string {
-	parts := make([]string, len(specs))
+	parts := make([]string, 0, len(specs))
 	for _, i := range specs {
 		parts = append(parts, i.toHuman())
 	}
 	return strings.Join(parts, ",")
 }
 
-// parseRetentionSpec parses one "resolution:retention" pair like "10s:6h"
+// formatRetentionListAsPoints renders specs in the "10s:2160" (resolution:points) form accepted
+// by whisper-create and whisper-resize.
+func formatRetentionListAsPoints(specs []ArchiveSpec) string {
+	parts := make([]string, 0, len(specs))
+	for _, s := range specs {
+		parts = append(parts, fmt.Sprintf("%s:%d", toHuman(s.SecondsPerPoint), s.points()))
+	}
+	return strings.Join(parts, ",")
+}
+
+// maxRetention returns the largest RetentionSecs in specs, i.e. the overall max age of a file.
+func maxRetention(specs []ArchiveSpec) int {
+	longest := 0
+	for _, s := range specs {
+		if s.RetentionSecs > longest {
+			longest = s.RetentionSecs
+		}
+	}
+	return longest
+}
+
+// finestResolution returns the smallest SecondsPerPoint in specs, 0 for no specs.
+func finestResolution(specs []ArchiveSpec) int {
+	finest := 0
+	for _, s := range specs {
+		if finest == 0 || s.SecondsPerPoint < finest {
+			finest = s.SecondsPerPoint
+		}
+	}
+	return finest
+}
+
+// parseRetentionSpec parses one "resolution:retention" pair like "10s:6h". Like whisper, a
+// retention without a unit is a number of points, so "10s:2160" as printed by --points and
+// accepted by whisper-resize is the same as "10s:6h".
 func parseRetentionSpec(pair string) (ArchiveSpec, error) {
 	parts := strings.Split(pair, ":")
 	if len(parts) != 2 {
@@ -110,9 +194,19 @@ func parseRetentionSpec(pair string) (ArchiveSpec, error) {
 	if err != nil {
 		return ArchiveSpec{}, fmt.Errorf("invalid resolution in %q: %v", pair, err)
 	}
-	retS, err := fromHuman(strings.TrimSpace(parts[1]))
-	if err != nil {
+	if resS <= 0 {
+		return ArchiveSpec{}, fmt.Errorf("invalid resolution in %q: must be at least one second", pair)
+	}
+	var retS int
+	if points, err := strconv.Atoi(strings.TrimSpace(parts[1])); err == nil {
+		if points <= 0 {
+			return ArchiveSpec{}, fmt.Errorf("invalid retention in %q: the number of points must be positive", pair)
+		}
+		retS = points * resS
+	} else if retS, err = fromHuman(strings.TrimSpace(parts[1])); err != nil {
 		return ArchiveSpec{}, fmt.Errorf("invalid retention in %q: %v", pair, err)
+	} else if retS <= 0 {
+		return ArchiveSpec{}, fmt.Errorf("invalid retention in %q: must be positive", pair)
 	}
 	// retention must be an integer multiple of resolution ideally, but we'll not enforce that strictly.
 	return ArchiveSpec{
@@ -149,10 +243,15 @@ func parseRetentionList(s string) ([]ArchiveSpec, error) {
 // pattern = REGEX
 // retentions = 10s:6h, 1m:7d
 //
-// Comments starting with # are ignored. The file is processed top-to-bottom and the
-// resulting slice preserves ordering so first match wins.
-func parseStorageSchemas(path string) ([]Schema, error) {
-	f, err := os.Open(path)
+// An optional patternFlags key (e.g. "i") is prepended to the pattern as (?flags), and an
+// optional matchType key (regex, prefix or literal) selects how the pattern is compared.
+// Indented continuation lines are appended to the previous key's value. Comments
+// starting with # are ignored. The file is processed top-to-bottom and the
+// resulting slice preserves ordering so first match wins. With strict, keys other than
+// knownSectionKeys are an error instead of being ignored. A path of "-" reads the file from
+// stdin.
+func parseStorageSchemas(path string, strict bool) ([]Schema, error) {
+	f, err := openConfig(path)
 	if err != nil {
 		return nil, err
 	}
@@ -164,131 +263,175 @@ func parseStorageSchemas(path string) ([]Schema, error) {
 		}
 	}()
 
-	scanner := bufio.NewScanner(f)
-	var schemas []Schema
-	var curName string
-	var curPattern string
-	var curRetentions string
-	lineNo := 0
-	sectionLine := 0
-
-	flushSection := func() error {
-		if curName == "" {
-			return nil
+	return readStorageSchemas(f, strict)
+}
+
+// readStorageSchemas parses a storage-schemas.conf from r, see parseStorageSchemas.
+func readStorageSchemas(r io.Reader, strict bool) ([]Schema, error) {
+	sections, err := readConfigSections(r)
+	if err != nil {
+		return nil, err
+	}
+	if strict {
+		if err = checkKnownKeys(sections); err != nil {
+			return nil, err
 		}
-		if curPattern == "" && curRetentions == "" {
+	}
+
+	var schemas []Schema
+	for _, sec := range sections {
+		pattern := sec.get("pattern")
+		retentions := sec.get("retentions")
+		flags := sec.get("patternflags")
+		if pattern == "" && retentions == "" {
 			// empty section: ignore
-			curName = ""
-			return nil
+			continue
+		}
+		var matchType string
+		matchType, err = parseMatchType(sec.Name, sec.get("matchtype"), flags)
+		if err != nil {
+			return nil, err
 		}
 		var compiled *regexp.Regexp
-		if curPattern != "" {
-			re, err := regexp.Compile(curPattern)
+		if pattern != "" && matchType == matchRegex {
+			compiled, err = compileSectionPattern(sec.Name, pattern, flags)
 			if err != nil {
-				return fmt.Errorf("failed compiling pattern %q in section [%s]: %v", curPattern, curName, err)
+				return nil, err
 			}
-			compiled = re
 		}
 		var retSpecs []ArchiveSpec
-		if curRetentions != "" {
-			rs, err := parseRetentionList(curRetentions)
+		if retentions != "" {
+			retSpecs, err = parseRetentionList(retentions)
 			if err != nil {
-				return fmt.Errorf("failed parsing retentions in section [%s]: %v", curName, err)
+				return nil, fmt.Errorf("failed parsing retentions in section [%s]: %v", sec.Name, err)
 			}
-			retSpecs = rs
 		}
 		schemas = append(schemas, Schema{
-			Name:       curName,
-			PatternRaw: curPattern,
-			Pattern:    compiled,
-			Retentions: retSpecs,
-			LineNo:     sectionLine,
+			Name:         sec.Name,
+			PatternRaw:   pattern,
+			PatternFlags: flags,
+			MatchType:    matchType,
+			Pattern:      compiled,
+			Retentions:   retSpecs,
+			LineNo:       sec.LineNo,
+			Comments:     sec.Comments,
 		})
-		curName = ""
-		curPattern = ""
-		curRetentions = ""
-		return nil
-	}
-
-	for scanner.Scan() {
-		lineNo++
-		line := scanner.Text()
-		trim := strings.TrimSpace(line)
-		// strip comments starting with #
-		if i := strings.Index(trim, "#"); i >= 0 {
-			trim = strings.TrimSpace(trim[:i])
-		}
-		if trim == "" {
-			continue
-		}
-		// section header
-		if strings.HasPrefix(trim, "[") && strings.HasSuffix(trim, "]") {
-			// flush previous
-			if err := flushSection(); err != nil {
-				return nil, err
-			}
-			curName = strings.TrimSpace(trim[1 : len(trim)-1])
-			sectionLine = lineNo
-			continue
-		}
-		// key = value lines
-		if eq := strings.Index(trim, "="); eq >= 0 {
-			key := strings.TrimSpace(trim[:eq])
-			val := strings.TrimSpace(trim[eq+1:])
-			switch strings.ToLower(key) {
-			case "pattern":
-				curPattern = val
-			case "retentions":
-				curRetentions = val
-			default:
-				// ignore other keys
-			}
-		}
-	}
-	// flush last
-	if err := flushSection(); err != nil {
-		return nil, err
-	}
-	if err := scanner.Err(); err != nil {
-		return nil, err
 	}
 	return schemas, nil
 }
 
-// findWhisperFiles walks root and returns all files ending with .wsp
-func findWhisperFiles(root string) ([]string, error) {
+// findWhisperFiles walks root and returns all files ending with .wsp, or with one of
+// opts.Extensions when set.
+// The result is always sorted so output built from it is reproducible across runs,
+// independent of the order the walk happens to visit files in. With opts.DedupeFiles,
+// hardlinks of a file already found are left out, and opts.MaxDepth limits how deep the walk
+// descends. With opts.Metrics only files whose metric name is listed are returned, with
+// opts.Prefix only metrics in that namespace, without walking the rest of the tree.
+func findWhisperFiles(root string, opts walkOptions) ([]string, error) {
+	return findWhisperFilesCtx(context.Background(), root, opts)
+}
+
+// findWhisperFilesCtx is findWhisperFiles stopping with ctx.Err() as soon as ctx is done.
+func findWhisperFilesCtx(ctx context.Context, root string, opts walkOptions) ([]string, error) {
 	out := []string{}
-	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+	// with a prefix only the directory of the prefix is descended into, plus its parent for the
+	// file of the metric named like the prefix itself
+	start, prefixDir := root, ""
+	if opts.Prefix != "" {
+		if _, err := os.Stat(root); err != nil {
+			return nil, err
+		}
+		prefixDir = strings.TrimSuffix(pathFromMetric(root, opts.Prefix), ".wsp")
+		start = filepath.Dir(prefixDir)
+	}
+	// WalkDir, unlike Walk, doesn't lstat every file it passes
+	err := filepath.WalkDir(start, func(path string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 		if err != nil {
+			// an unreadable root is an error, not an empty tree
+			if path == root {
+				return err
+			}
+			// a prefix with no metrics under it is
+			if path == start && os.IsNotExist(err) {
+				return nil
+			}
 			// Skip unreadable files/directories
 			fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", path, err)
 			return nil // <- IMPORTANT: continue walking
 			// don't stop on single file errors; but return error if stat fails
 			// return err
 		}
-		if info.IsDir() {
+		if d.IsDir() {
+			if opts.MaxDepth > 0 && dirDepth(root, path) > opts.MaxDepth {
+				return filepath.SkipDir
+			}
+			if prefixDir != "" && path != start && path != prefixDir && !strings.HasPrefix(path, prefixDir+string(filepath.Separator)) {
+				return filepath.SkipDir
+			}
 			return nil
 		}
-		if strings.HasSuffix(strings.ToLower(path), ".wsp") {
+		if opts.matchesExtension(path) && opts.keepMetric(root, path) {
 			out = append(out, path)
 		}
 		return nil
 	})
+	sort.Strings(out)
+	if err == nil && opts.DedupeFiles {
+		out = dedupeFiles(out)
+	}
 	return out, err
 }
 
-// metricFromPath converts a filesystem path to Graphite metric name relative to root.
+// metricFromPath converts a filesystem path to Graphite metric name relative to root, with the
+// extension ext stripped when the path ends with it, compared case-insensitively.
 // e.g. /var/lib/graphite/whisper/servers/web01/cpu.wsp -> servers.web01.cpu
-func metricFromPath(root, full string) string {
+// Other dots in the file name are kept, so a/c.d.wsp is a.c.d rather than the same metric as
+// a/c.wsp. With normalize, empty path segments and stray dots are cleaned up (see
+// normalizeMetricName), so a/.b/c..wsp still yields a.b.c and matches sane patterns.
+func metricFromPath(root, full, ext string, normalize bool) string {
+	// filepath.Rel can't relate a relative root to an absolute file or vice versa, resolve both
+	// against the working directory first
+	if filepath.IsAbs(root) != filepath.IsAbs(full) {
+		if absRoot, err := filepath.Abs(root); err == nil {
+			if absFull, err := filepath.Abs(full); err == nil {
+				root, full = absRoot, absFull
+			}
+		}
+	}
 	rel, err := filepath.Rel(root, full)
 	if err != nil {
 		// fallback to full path turned into dots (not ideal)
 		rel = full
 	}
-	rel = strings.TrimSuffix(rel, ".wsp")
+	if ext != "" && strings.HasSuffix(strings.ToLower(rel), strings.ToLower(ext)) {
+		rel = rel[:len(rel)-len(ext)]
+	}
 	// on Windows or other OSes, ensure separators are normalized
 	rel = strings.TrimPrefix(rel, string(filepath.Separator))
-	return strings.ReplaceAll(rel, string(filepath.Separator), ".")
+	metric := strings.ReplaceAll(rel, string(filepath.Separator), ".")
+	if normalize {
+		metric = normalizeMetricName(metric)
+	}
+	return metric
+}
+
+// normalizeMetricName collapses runs of dots into one and drops leading and trailing dots,
+// e.g. ".a..b." -> "a.b".
+func normalizeMetricName(metric string) string {
+	for strings.Contains(metric, "..") {
+		metric = strings.ReplaceAll(metric, "..", ".")
+	}
+	return strings.Trim(metric, ".")
+}
+
+// pathFromMetric is the inverse of metricFromPath: it maps a Graphite metric name to the .wsp
+// file carbon would store it in under root.
+// e.g. servers.web01.cpu -> /var/lib/graphite/whisper/servers/web01/cpu.wsp
+func pathFromMetric(root, metric string) string {
+	return filepath.Join(root, strings.ReplaceAll(metric, ".", string(filepath.Separator))+".wsp")
 }
 
 // whisperRetentionsToSpecs converts whisper.Retentions() -> []ArchiveSpec preserving order.
@@ -306,29 +449,259 @@ func whisperRetentionsToSpecs(retentions []whisper.Retention) []ArchiveSpec {
 	return out
 }
 
-func compareSpecsEqual(a, b []ArchiveSpec) bool {
+// matchSchema returns the first schema (top-to-bottom) whose pattern matches metric, or nil.
+func matchSchema(schemas []Schema, metric string) *Schema {
+	for i := range schemas {
+		s := &schemas[i]
+		// If pattern is empty treat as no-match (Graphite typically has pattern)
+		if s.PatternRaw == "" {
+			continue
+		}
+		if sectionMatches(s.MatchType, s.PatternRaw, s.Pattern, metric) {
+			return s
+		}
+	}
+	return nil
+}
+
+// fileAttrs are the attributes of a whisper file --check-retention compares.
+type fileAttrs struct {
+	Specs             []ArchiveSpec
+	AggregationMethod whisper.AggregationMethod
+	XFilesFactor      float32
+}
+
+// readFileSpecs returns the archives of the whisper file at path as []ArchiveSpec, see
+// readFileAttrs.
+func readFileSpecs(path string) ([]ArchiveSpec, error) {
+	attrs, err := readFileAttrs(path)
+	return attrs.Specs, err
+}
+
+// readFileAttrs returns the archives, aggregation method and xFilesFactor of the whisper file at
+// path. Only the header is read, from a file opened read-only: this is cheaper than
+// whisper.Open, which opens files read-write and reads the header piecewise, and it works
+// without write permission. Files in go-whisper's compressed format are opened with
+// go-whisper, also read-only.
+func readFileAttrs(path string) (fileAttrs, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return fileAttrs{}, err
+	}
+	header, err := readWhisperHeader(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if errors.Is(err, errCompressedWhisper) {
+		return readCompressedFileAttrs(path)
+	}
+	if err != nil {
+		return fileAttrs{}, err
+	}
+	return headerAttrs(header), nil
+}
+
+// headerAttrs returns the fileAttrs described by a classic whisper header.
+func headerAttrs(header *whisperHeader) fileAttrs {
+	attrs := fileAttrs{
+		Specs:             make([]ArchiveSpec, 0, len(header.Archives)),
+		AggregationMethod: header.AggregationMethod,
+		XFilesFactor:      header.XFilesFactor,
+	}
+	for _, a := range header.Archives {
+		attrs.Specs = append(attrs.Specs, ArchiveSpec{SecondsPerPoint: a.SecondsPerPoint, RetentionSecs: a.Retention()})
+	}
+	return attrs
+}
+
+// readCompressedFileAttrs is readFileAttrs for go-whisper's compressed format.
+func readCompressedFileAttrs(path string) (fileAttrs, error) {
+	readOnly := os.O_RDONLY
+	w, err := whisper.OpenWithOptions(path, &whisper.Options{OpenFileFlag: &readOnly})
+	if err != nil {
+		return fileAttrs{}, err
+	}
+	attrs := whisperAttrs(w)
+	if err := w.Close(); err != nil {
+		return fileAttrs{}, err
+	}
+	return attrs, nil
+}
+
+// whisperAttrs returns the fileAttrs of an opened whisper file.
+func whisperAttrs(w *whisper.Whisper) fileAttrs {
+	return fileAttrs{Specs: whisperRetentionsToSpecs(w.Retentions()), AggregationMethod: w.AggregationMethod(), XFilesFactor: w.XFilesFactor()}
+}
+
+// specsEqualNormalized reports whether a and b describe the same archives once their retentions
+// are rounded down to whole points (see ArchiveSpec.normalized), so a schema retention that
+// isn't a multiple of its resolution equals what whisper created from it.
+func specsEqualNormalized(a, b []ArchiveSpec) bool {
 	if len(a) != len(b) {
 		return false
 	}
 	for i := range a {
-		if a[i].SecondsPerPoint != b[i].SecondsPerPoint || a[i].RetentionSecs != b[i].RetentionSecs {
+		if a[i].normalized() != b[i].normalized() {
 			return false
 		}
 	}
 	return true
 }
 
+// isFlagSet reports whether the named flag was given on the command line or, once
+// applyConfigFile ran, in the config file.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
 func main() {
+	fileStats := flag.Bool("file-stats", false, "with info, also print the file's size on disk and its last modification time")
+	prefix := flag.String("prefix", "", "when walking a tree, only include metrics in this namespace (e.g. servers.web), walking only its directory")
+	metricsFile := flag.String("metrics-file", "", "when walking a tree, only include metrics listed in this file, one metric name or Graphite glob per line")
+	noNormalize := flag.Bool("no-normalize", false, "when walking a tree, match schemas against metric names exactly as derived from paths instead of collapsing repeated dots and trimming leading/trailing ones")
+	cacheDir := flag.String("cache-dir", "", "with info of an http(s) URL, keep the downloaded file in this directory and reuse it instead of downloading again")
+	maxDownload := flag.Int64("max-download", defaultMaxDownload, "with info of an http(s) URL, abort downloads larger than this many bytes")
+	tarPath := flag.String("tar", "", "read whisper files from this tar archive (optionally gzip-compressed) instead of the file system: with info, the argument is the entry name; with --check-retention, every .wsp entry is checked")
+	rawOffsets := flag.Bool("raw-offsets", false, "print the header size and the byte range of every archive of a single file")
+	pointsFlag := flag.Bool("points", false, "with --short and info, print retentions as resolution:points (e.g. 10s:2160) like whisper-resize expects")
+	tableStyle := flag.String("table-style", "plain", "how tables of info, --check-retention and --count are drawn: "+strings.Join(tableStyles, ", ")+" (ascii and unicode draw cell borders)")
 	shortFlag := flag.Bool("short", false, "print retention in storage-schemas.conf format (e.g. 300s:60d, 1h:2y) for a single file")
-	checkFlag := flag.Bool("check-retention", false, "check retentions for all .wsp files under ROOT using the provided storage-schemas.conf")
-	schemasPath := flag.String("schemas", "", "path to storage-schemas.conf (required when --check-retention is used)")
+	checkFlag := flag.Bool("check-retention", false, "check retentions for all .wsp files under one or more ROOTs using the provided storage-schemas.conf")
+	var extensions stringList
+	flag.Var(&extensions, "extension", "when walking a tree, include files with this suffix instead of .wsp, case-insensitive (repeatable, e.g. --extension=.wsp --extension=.wsp.bak)")
+	maxDepth := flag.Int("max-depth", 0, "when walking a tree, don't descend more than this many directory levels below the root (0 = unlimited)")
+	dedupeFiles := flag.Bool("dedupe-files", false, "when walking a tree, report hardlinked .wsp files once, under their first path")
+	flag.IntVar(&maxConfigLine, "max-config-line", defaultMaxConfigLine, "longest line accepted in --schemas and --aggregation files, in bytes")
+	strictParse := flag.Bool("strict-parse", false, "fail on unknown keys in --schemas and --aggregation sections instead of ignoring them")
+	schemasPath := flag.String("schemas", "", "path to storage-schemas.conf (required when --check-retention is used), - reads it from stdin")
 	exitOnMismatch := flag.Bool("exit-on-mismatch", true, "exit with non-zero code if any mismatch is found (default true)")
+	showPath := flag.Bool("show-path", false, "with --check-retention, add a column with the .wsp file path of each metric")
+	format := flag.String("format", "table", "output format: "+strings.Join(checkFormats, ", ")+" for --check-retention; table, json for --summary")
+	reportFiner := flag.Bool("report-finer", false, "with --check-retention, report files whose finest archive is finer than the schema's as FINER instead of MISMATCH")
+	tolerance := flag.String("tolerance", "0", "with --check-retention, accept retentions off by up to this many points (e.g. 1) or this duration (e.g. 10m)")
+	query := flag.String("query", "", "with --check-retention or --set-xff, only include metrics matching this Graphite glob (e.g. 'servers.*.{cpu,mem}')")
+	timingFlag := flag.Bool("timing", false, "with --check-retention or --count, print the wall time, files per second and the time spent walking, opening and comparing to stderr (phase times add up over all --workers)")
+	failOn := flag.String("fail-on", "warn", "with --check-retention, the lowest mismatch severity that makes the run fail: warn (any mismatch) or error (only mismatches losing history the schema asks for)")
+	pushGateway := flag.String("push-gateway", "", "with --check-retention, push the number of files per status to this Prometheus Pushgateway URL when done (e.g. http://pushgw:9091/metrics/job/whisper-tools)")
+	explain := flag.Bool("explain", false, "with --check-retention, list the schemas each metric was tested against in order, up to its first match (not with --format=csv)")
+	workers := flag.Int("workers", 1, "with --check-retention, number of files to check concurrently")
+	showComments := flag.Bool("show-comments", false, "with --count, add a column with the comment lines above each schema in --schemas (e.g. # owner: team-x)")
+	verbose := flag.Bool("verbose", false, "with --check-retention, append the points of every archive, actual vs expected, to the detail of mismatching files")
+	scoreFlag := flag.Bool("score", false, "with --check-retention, print a health score of the checked files instead of one row per file: 100 * (ok + 0.5 * warn) / files, where warn are mismatches keeping at least the expected history (use --format=json for its components)")
+	templateText := flag.String("template", "", "with --check-retention --format=template, render every result with this Go text/template (e.g. '{{.Metric}} {{.Status}}'); fields: Source, Status, Metric, Schema, Expected, Actual, Detail, Severity, Path, MatchTrace")
+	templateFile := flag.String("template-file", "", "with --check-retention --format=template, read the template from this file instead of --template")
+	streamFlag := flag.Bool("stream", false, "with --check-retention, print every result as soon as it is checked instead of at the end, in file order (the table format is printed like plain, unaligned)")
+	allFlag := flag.Bool("all", false, "with --check-retention and --aggregation, also compare the aggregation method and xFilesFactor of every file and report one status per file, naming every attribute that differs")
+	fileTimeout := flag.Duration("file-timeout", 0, "with --check-retention, report a file as ERROR and move on when opening and reading it takes longer than this (e.g. 5s, 0 waits forever)")
+	cachePath := flag.String("cache", "", "with --check-retention, reuse results for files whose mtime and size are unchanged since the last run, stored in this file")
+	allowEmpty := flag.Bool("allow-empty", false, "with --check-retention, only warn (and exit 0) when a root contains no .wsp files")
+	countFlag := flag.Bool("count", false, "count how many .wsp files under one or more ROOTs each schema in --schemas matches (first match wins)")
+	doctorFlag := flag.Bool("doctor", false, "check that --schemas and --aggregation parse and that ROOT holds whisper files go-whisper can open, printing a pass/fail checklist")
+	graphFlag := flag.Bool("graph", false, "print --schemas as a Graphviz DOT graph of its sections in order, with edges where an earlier pattern may shadow a later one")
+	deadSchemasFlag := flag.Bool("dead-schemas", false, "list the sections of --schemas that match no metric under any of the ROOTs (exit non-zero with --fail-on-zero)")
+	members := flag.String("members", "", "list the metrics under ROOT whose first matching schema in --schemas is NAME (NOMATCH lists unmatched metrics)")
+	maxCount := flag.Int("max-count", 0, "with --count, exit non-zero if any schema matches more than this many metrics (0 disables)")
+	failOnZero := flag.Bool("fail-on-zero", false, "with --count or --dead-schemas, exit non-zero if any schema matches no metrics")
+	defaultXFF := flag.Float64("default-xff", float64(defaultXFilesFactor), "xFilesFactor for new files when --aggregation doesn't set one, within [0,1] (env "+defaultXFFEnv+")")
+	reportCreateRate := flag.Bool("report-create-rate", false, "with --plan, report how many of the files don't exist yet and how long carbon needs to create them at --creates-per-minute")
+	createsPerMinute := flag.Float64("creates-per-minute", carbonMaxCreatesPerMinute, "with --report-create-rate, carbon's MAX_CREATES_PER_MINUTE")
+	planFlag := flag.Bool("plan", false, "read metric names from stdin and print the schema, aggregation and path each would be created with under ROOT, without creating anything")
+	histogramFlag := flag.Bool("histogram", false, "print how many .wsp files under ROOT share each distinct retention configuration")
+	aggregationHistogramFlag := flag.Bool("aggregation-histogram", false, "print how many .wsp files under ROOT share each aggregation method and xFilesFactor")
+	summaryFlag := flag.Bool("summary", false, "print an overview of the tree under ROOT (also used when info is pointed at a directory)")
+	lintNamesFlag := flag.Bool("lint-names", false, "report .wsp files under ROOT whose metric names contain problematic characters (read-only)")
+	suggestFlag := flag.Bool("suggest", false, "with --lint-names, print a suggested sanitized metric name")
+	validateFlag := flag.Bool("validate", false, "validate --schemas and/or --aggregation config files without reading any whisper files")
+	aggregationPath := flag.String("aggregation", "", "path to storage-aggregation.conf")
+	allowedAggregations := flag.String("allowed-aggregations", "", "with --validate, comma separated aggregation methods rules may use (e.g. average,sum)")
+	minResolutionPolicy := flag.String("min-resolution", "", "with --validate, flag retention specs finer than this resolution (e.g. 10s)")
+	maxRetentionPolicy := flag.String("max-retention", "", "with --validate, flag retention specs kept longer than this (e.g. 2y)")
+	carbonInterval := flag.String("carbon-interval", "", "with --validate, warn about schemas whose finest resolution is finer than how often carbon writes a metric (e.g. 10s)")
+	warnSuspicious := flag.Bool("warn-suspicious", false, "with --validate, warn about retention specs keeping fewer than 10 points, which usually means a unit typo (e.g. 1h:2m for 1h:2w)")
+	fetchFlag := flag.Bool("fetch", false, "print the datapoints of a single file, one timestamp and value per line")
+	archiveIndex := flag.Int("archive", -1, "with --fetch, read this archive (0 = finest) instead of selecting one from --from like whisper does; with --gaps, the archive to scan (default 0)")
+	gapsFlag := flag.Bool("gaps", false, "list the largest runs of null points in an archive of a single file, with their start, end and duration (use --format=json for JSON)")
+	gapsTop := flag.Int("top", 10, "with --gaps, how many gaps to list (0 lists all)")
+	annotate := flag.Bool("annotate", false, "with --fetch, add columns with the archive each point was read from and its aggregation method (raw for the finest archive)")
+	nullAs := flag.String("null-as", "None", "with --fetch, how to print null points: skip (omit the line), empty, nan, or a literal string")
+	fetchFrom := flag.String("from", "", "with --fetch or --gaps, how far back to read (e.g. 6h); --fetch defaults to 24h, or the whole archive with --archive, --gaps to the whole archive")
+	timezone := flag.String("timezone", "", "render timestamps as RFC 3339 in this IANA time zone (e.g. UTC, Local, Europe/Berlin) instead of unix epoch seconds")
+	testPatternFlag := flag.String("test-pattern", "", "print which of the metric names given as arguments (or on stdin) match this regex")
+	resolveFlag := flag.String("resolve", "", "print the schema and aggregation carbon would apply to this metric name, using --schemas and optionally --aggregation")
+	metricFlag := flag.String("metric", "", "print the first schema in --schemas this metric name matches")
+	configPath := flag.String("config", "", "read flag defaults from this file instead of "+configFileName+" in the working directory or $HOME")
+	versionFlag := flag.Bool("version", false, "print version, commit, build date and the linked go-whisper version")
+	completion := flag.String("completion", "", "print a shell completion script for "+strings.Join(completionShells, ", ")+" (e.g. source <(go-whisper-tools --completion=bash))")
+	densityFlag := flag.Bool("density", false, "list the .wsp files under ROOT whose finest archive is filled less than --below (use --format=json for JSON)")
+	densityBelow := flag.String("below", "100%", "with --density, only list files with a finest-archive density below this percentage")
+	futureFlag := flag.Bool("future", false, "list the .wsp files under ROOT holding points timestamped after now, e.g. from collectors with a skewed clock (use --format=json for JSON)")
+	futureTolerance := flag.String("future-tolerance", "0s", "with --future, ignore points up to this far ahead of now (e.g. 60s)")
+	snapshotOut := flag.String("snapshot", "", "record the retentions, aggregation and xFilesFactor of every metric under ROOT to this JSON file")
+	snapshotDiff := flag.String("snapshot-diff", "", "report metrics under ROOT added, removed or changed since the snapshot in this file")
+	verifyFlag := flag.Bool("verify", false, "check that every .wsp file under ROOT is an intact whisper file and summarize failures by category")
+	touchFlag := flag.Bool("touch", false, "create an empty .wsp file for a metric with the retentions of its --schemas match: --touch ROOT METRIC")
+	renameFlag := flag.Bool("rename", false, "move the .wsp file of metric OLD to NEW under the given root: --rename ROOT OLD NEW")
+	renameMap := flag.String("map", "", "with --rename, read tab separated \"old new\" metric names from this file instead: --rename --map=FILE ROOT")
+	overwrite := flag.Bool("overwrite", false, "with --rename, replace an existing destination file")
+	dryRun := flag.Bool("dry-run", false, "with --rename, --touch, --set-xff or --add-archive, only print what would be done")
+	setXFF := flag.String("set-xff", "", "rewrite the xFilesFactor of every .wsp file under ROOT (or those matching --query) to this value within [0,1], in place")
+	repairFlag := flag.Bool("repair", false, "report archives of a single file whose base interval is corrupt (dry run unless --apply is given)")
+	repairApply := flag.Bool("apply", false, "with --repair, rewrite the corrupt base intervals")
+	simulateFlag := flag.Bool("simulate", false, "show which archives of a single file an update would write and with which aggregated values, without writing")
+	simulateValue := flag.Float64("value", 0, "with --simulate, the value of the update")
+	simulateTimestamp := flag.Int("timestamp", 0, "with --simulate, the unix timestamp of the update (default now)")
+	estimateLoss := flag.String("estimate-loss", "", "dry-run a resize of a single file to the given retentions (e.g. 10s:1d,1m:7d) and report how many points would be lost")
+	addArchiveSpec := flag.String("add-archive", "", "add an empty archive with this retention (e.g. 1s:1h) to a single file, keeping the data of all other archives")
 	flag.Usage = func() {
-		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] path/to/metric.wsp | path/to/whisper_root\n\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] path/to/metric.wsp | path/to/whisper_root [more roots...]\n\n", os.Args[0])
 		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "Examples:\n")
 		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s /var/lib/graphite/whisper/servers.web01.cpu.wsp\n", os.Args[0])
 		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --short /var/lib/graphite/whisper/servers.web01.cpu.wsp\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --cache-dir=/tmp/whisper-cache https://graphite01/whisper/servers/web01/cpu.wsp\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --doctor --schemas=/etc/graphite/storage-schemas.conf /var/lib/graphite/whisper\n", os.Args[0])
 		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --check-retention --schemas=/etc/graphite/storage-schemas.conf /var/lib/graphite/whisper\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  generate-schemas | %s --check-retention --schemas=- /var/lib/graphite/whisper\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --check-retention --metrics-file=audit.txt --schemas=/etc/graphite/storage-schemas.conf /var/lib/graphite/whisper\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --check-retention --tar=backup.tar.gz --schemas=/etc/graphite/storage-schemas.conf\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --check-retention --all --schemas=/etc/graphite/storage-schemas.conf --aggregation=/etc/graphite/storage-aggregation.conf /var/lib/graphite/whisper\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --tar=backup.tar.gz servers/web01/cpu.wsp\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --count --max-count=10000 --fail-on-zero --schemas=/etc/graphite/storage-schemas.conf /var/lib/graphite/whisper\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --dead-schemas --fail-on-zero --schemas=/etc/graphite/storage-schemas.conf /var/lib/graphite/whisper\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --members=servers --schemas=/etc/graphite/storage-schemas.conf /var/lib/graphite/whisper\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  cat metrics.txt | %s --plan --schemas=/etc/graphite/storage-schemas.conf --aggregation=/etc/graphite/storage-aggregation.conf /var/lib/graphite/whisper\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --validate --aggregation=/etc/graphite/storage-aggregation.conf --allowed-aggregations=average,sum\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --validate --schemas=/etc/graphite/storage-schemas.conf --min-resolution=10s --max-retention=2y\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --graph --schemas=/etc/graphite/storage-schemas.conf | dot -Tsvg > schemas.svg\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --summary --format=json /var/lib/graphite/whisper\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --histogram /var/lib/graphite/whisper\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --aggregation-histogram /var/lib/graphite/whisper\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --test-pattern='^servers\\.' servers.web01.cpu carbon.agents.a\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --metric=servers.web01.cpu --schemas=/etc/graphite/storage-schemas.conf\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --resolve=servers.web01.cpu --schemas=/etc/graphite/storage-schemas.conf --aggregation=/etc/graphite/storage-aggregation.conf\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --lint-names --suggest /var/lib/graphite/whisper\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --density --below=50%% /var/lib/graphite/whisper\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --future --future-tolerance=60s /var/lib/graphite/whisper\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --snapshot=snap.json /var/lib/graphite/whisper\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --snapshot-diff=snap.json /var/lib/graphite/whisper\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --verify --exit-on-mismatch /var/lib/graphite/whisper\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --fetch --archive=1 /var/lib/graphite/whisper/servers.web01.cpu.wsp\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --gaps --top=5 --timezone=UTC /var/lib/graphite/whisper/servers.web01.cpu.wsp\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --repair --apply /var/lib/graphite/whisper/servers.web01.cpu.wsp\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --simulate --value=42 /var/lib/graphite/whisper/servers.web01.cpu.wsp\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --estimate-loss=10s:1d,1m:7d /var/lib/graphite/whisper/servers.web01.cpu.wsp\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --add-archive=1s:1h --dry-run /var/lib/graphite/whisper/servers.web01.cpu.wsp\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --touch --schemas=/etc/graphite/storage-schemas.conf --aggregation=/etc/graphite/storage-aggregation.conf /var/lib/graphite/whisper servers.web03.cpu\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --rename --dry-run /var/lib/graphite/whisper servers.web01.cpu servers.web01.cpu_total\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --rename --map=mappings.tsv /var/lib/graphite/whisper\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "  %s --set-xff=0.3 --query='servers.*.cpu' --dry-run /var/lib/graphite/whisper\n", os.Args[0])
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "\nFlag defaults can be set as \"flag = value\" lines in %s in the working directory or $HOME,\nfor these flags: %s.\n", configFileName, strings.Join(configFileFlags, ", "))
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "\nExit codes: %d ok, %d mismatches found, %d usage error, %d I/O or parse error.\n", exitOK, exitMismatch, exitUsage, exitError)
 		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "\nOptions:\n")
 		flag.PrintDefaults()
 	}
@@ -336,154 +709,919 @@ func main() {
 
 	var err error
 
-	if flag.NArg() < 1 {
+	// fill in flags not given on the command line from the config file
+	cfg := *configPath
+	if cfg == "" {
+		cfg = findConfigFile()
+	}
+	if cfg != "" {
+		if err = applyConfigFile(cfg); errors.Is(err, errConfigFlagNotAllowed) || errors.Is(err, errConfigUnknownFlag) {
+			usageFatalf("invalid config: %v\n", err)
+		} else if err != nil {
+			fatalf("failed to read config: %v\n", err)
+		}
+	}
+
+	// before any mode, --version only prints the version
+	if *versionFlag {
+		printVersion(os.Stdout)
+		return
+	}
+
+	if !slices.Contains(tableStyles, *tableStyle) {
+		usageFatalf("invalid --table-style %q: must be one of %s\n", *tableStyle, strings.Join(tableStyles, ", "))
+	}
+	if *maxDepth < 0 {
+		usageFatalf("invalid --max-depth %d: must not be negative\n", *maxDepth)
+	}
+	if maxConfigLine < 1 {
+		usageFatalf("invalid --max-config-line %d: must be positive\n", maxConfigLine)
+	}
+	walk := walkOptions{DedupeFiles: *dedupeFiles, MaxDepth: *maxDepth, Extensions: extensions, NoNormalize: *noNormalize}
+	if *prefix != "" {
+		walk.Prefix = normalizeMetricName(*prefix)
+		if walk.Prefix == "" {
+			usageFatalf("invalid --prefix %q\n", *prefix)
+		}
+	}
+	if *metricsFile != "" {
+		walk.Metrics, err = readMetricList(*metricsFile)
+		if err != nil {
+			usageFatalf("failed to read --metrics-file %s: %v\n", *metricsFile, err)
+		}
+	}
+
+	var loc *time.Location
+	if *timezone != "" {
+		loc, err = time.LoadLocation(*timezone)
+		if err != nil {
+			usageFatalf("invalid --timezone: %v\n", err)
+		}
+	}
+
+	// validate mode only reads config files, so it doesn't take a path argument
+	if *validateFlag {
+		if *schemasPath == "" && *aggregationPath == "" {
+			usageFatal("--schemas or --aggregation is required when --validate is used")
+		}
+		opts := validateOptions{
+			SchemasPath:     *schemasPath,
+			AggregationPath: *aggregationPath,
+			StrictParse:     *strictParse,
+			WarnSuspicious:  *warnSuspicious,
+		}
+		if *allowedAggregations != "" {
+			opts.AllowedAggregations, err = parseAllowedAggregations(*allowedAggregations)
+			if err != nil {
+				usageFatalf("invalid --allowed-aggregations: %v\n", err)
+			}
+		}
+		if *minResolutionPolicy != "" {
+			opts.MinResolution, err = fromHuman(*minResolutionPolicy)
+			if err != nil {
+				usageFatalf("invalid --min-resolution: %v\n", err)
+			}
+		}
+		if *maxRetentionPolicy != "" {
+			opts.MaxRetention, err = fromHuman(*maxRetentionPolicy)
+			if err != nil {
+				usageFatalf("invalid --max-retention: %v\n", err)
+			}
+		}
+		if *carbonInterval != "" {
+			opts.CarbonInterval, err = fromHuman(*carbonInterval)
+			if err == nil && opts.CarbonInterval <= 0 {
+				err = fmt.Errorf("must be positive")
+			}
+			if err != nil {
+				usageFatalf("invalid --carbon-interval: %v\n", err)
+			}
+		}
+		var issues []validationIssue
+		issues, err = validateConfigs(opts)
+		if err != nil {
+			fatal(err)
+		}
+		var hasError bool
+		hasError, err = printValidationIssues(issues)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error flushing TabWriter")
+		}
+		if hasError {
+			os.Exit(exitMismatch)
+		}
+		return
+	}
+
+	// doctor mode: a checklist of the setup, ROOT is optional
+	if *doctorFlag {
+		if flag.NArg() > 1 {
+			usageFatal("--doctor takes at most one ROOT")
+		}
+		if *schemasPath == "" && *aggregationPath == "" && flag.NArg() == 0 {
+			usageFatal("--doctor needs --schemas, --aggregation or a ROOT to check")
+		}
+		if !printDoctor(os.Stdout, runDoctor(*schemasPath, *aggregationPath, flag.Arg(0), *strictParse, walk)) {
+			os.Exit(exitMismatch)
+		}
+		return
+	}
+
+	// graph mode only reads the schemas file
+	if *graphFlag {
+		if *schemasPath == "" {
+			usageFatal("--schemas is required when --graph is used")
+		}
+		var schemas []Schema
+		schemas, err = parseStorageSchemas(*schemasPath, *strictParse)
+		if err != nil {
+			fatalf("failed to parse schemas %s: %v\n", *schemasPath, err)
+		}
+		if err = writeSchemaGraph(os.Stdout, schemas); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if *completion != "" {
+		if err = writeCompletion(os.Stdout, *completion, os.Args[0]); err != nil {
+			usageFatal(err)
+		}
+		return
+	}
+
+	// pattern tester: metric names come from the arguments or stdin
+	if *testPatternFlag != "" {
+		var re *regexp.Regexp
+		re, err = regexp.Compile(*testPatternFlag)
+		if err != nil {
+			usageFatalf("invalid --test-pattern: %v\n", err)
+		}
+		metrics := flag.Args()
+		if len(metrics) == 0 {
+			metrics, err = readMetricNames(os.Stdin)
+			if err != nil {
+				fatalf("failed reading metrics from stdin: %v\n", err)
+			}
+		}
+		if _, err = testPattern(re, metrics); err != nil {
+			fmt.Fprintln(os.Stderr, "error flushing TabWriter")
+		}
+		return
+	}
+
+	// single metric lookup against the schemas
+	if *metricFlag != "" {
+		if *schemasPath == "" {
+			usageFatal("--schemas is required when --metric is used")
+		}
+		var schemas []Schema
+		schemas, err = parseStorageSchemas(*schemasPath, *strictParse)
+		if err != nil {
+			fatalf("failed to parse schemas %s: %v\n", *schemasPath, err)
+		}
+		var matched bool
+		matched, err = printSchemaMatch(schemas, *metricFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error flushing TabWriter")
+		}
+		if !matched && *exitOnMismatch {
+			os.Exit(exitMismatch)
+		}
+		return
+	}
+
+	// effective schema and aggregation for a single metric
+	if *resolveFlag != "" {
+		if *schemasPath == "" {
+			usageFatal("--schemas is required when --resolve is used")
+		}
+		var schemas []Schema
+		schemas, err = parseStorageSchemas(*schemasPath, *strictParse)
+		if err != nil {
+			fatalf("failed to parse schemas %s: %v\n", *schemasPath, err)
+		}
+		var rules []AggregationRule
+		if *aggregationPath != "" {
+			rules, err = parseStorageAggregation(*aggregationPath, *strictParse)
+			if err != nil {
+				fatalf("failed to parse aggregation %s: %v\n", *aggregationPath, err)
+			}
+		}
+		var xff float32
+		xff, err = resolveDefaultXFF(*defaultXFF, isFlagSet("default-xff"))
+		if err != nil {
+			usageFatal(err)
+		}
+		if !writeResolution(os.Stdout, schemas, rules, *resolveFlag, xff) && *exitOnMismatch {
+			os.Exit(exitMismatch)
+		}
+		return
+	}
+
+	// with --tar, --check-retention reads the archive instead of ROOTs
+	if flag.NArg() < 1 && (*tarPath == "" || !*checkFlag) {
 		flag.Usage()
-		os.Exit(2)
+		os.Exit(exitUsage)
 	}
 	path := flag.Arg(0)
 
 	// single-file short mode
 	if *shortFlag && !*checkFlag {
-		var w *whisper.Whisper
-		w, err = whisper.Open(path)
+		var specs []ArchiveSpec
+		specs, err = readFileSpecs(path)
 		if err != nil {
-			log.Fatalf("Error opening '%s': %v\n", path, err)
+			fatalf("Error opening '%s': %v\n", path, err)
+		}
+		if *pointsFlag {
+			fmt.Println(formatRetentionListAsPoints(specs))
+		} else {
+			fmt.Println(formatRetentionList(specs))
 		}
-		defer func() {
-			err = w.Close()
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error closing '%s': %v\n", path, err)
-			}
-		}()
-		specs := whisperRetentionsToSpecs(w.Retentions())
-		fmt.Println(formatRetentionList(specs))
 		return
 	}
 
 	// check-retention mode
 	if *checkFlag {
 		if *schemasPath == "" {
-			log.Fatal("--schemas is required when --check-retention is used")
+			usageFatal("--schemas is required when --check-retention is used")
+		}
+		if !slices.Contains(checkFormats, *format) {
+			usageFatalf("invalid --format %q: must be one of %s\n", *format, strings.Join(checkFormats, ", "))
+		}
+		if !slices.Contains(failOnLevels, *failOn) {
+			usageFatalf("invalid --fail-on %q: must be one of %s\n", *failOn, strings.Join(failOnLevels, ", "))
+		}
+		if *explain && *format == "csv" {
+			usageFatal("--explain can't be used with --format=csv")
+		}
+		var tmpl *template.Template
+		if *format == "template" {
+			if (*templateText == "") == (*templateFile == "") {
+				usageFatal("--format=template needs exactly one of --template or --template-file")
+			}
+			tmpl, err = parseCheckTemplate(*templateText, *templateFile)
+			if err != nil {
+				usageFatalf("invalid template: %v\n", err)
+			}
+		} else if *templateText != "" || *templateFile != "" {
+			usageFatal("--template and --template-file need --format=template")
+		}
+		if *scoreFlag && *format != "table" && *format != "plain" && *format != "json" {
+			usageFatalf("invalid --format %q: --score supports table, plain, json\n", *format)
+		}
+		tol, err := parseTolerance(*tolerance)
+		if err != nil {
+			usageFatalf("invalid --tolerance: %v\n", err)
 		}
 		var schemas []Schema
-		schemas, err = parseStorageSchemas(*schemasPath)
+		schemas, err = parseStorageSchemas(*schemasPath, *strictParse)
 		if err != nil {
-			log.Fatalf("failed to parse schemas %s: %v\n", *schemasPath, err)
+			fatalf("failed to parse schemas %s: %v\n", *schemasPath, err)
+		}
+		opts := checkOptions{ShowPath: *showPath, Format: *format, ReportFiner: *reportFiner, AllowEmpty: *allowEmpty, Tolerance: tol, Workers: *workers, Query: *query, Walk: walk, Explain: *explain, FailOn: *failOn, TableStyle: *tableStyle, FileTimeout: *fileTimeout, Template: tmpl, Verbose: *verbose, Stream: *streamFlag}
+		if *allFlag {
+			if *aggregationPath == "" {
+				usageFatal("--all needs --aggregation")
+			}
+			opts.All = true
+			opts.AggregationRules, err = parseStorageAggregation(*aggregationPath, *strictParse)
+			if err != nil {
+				fatalf("failed to parse aggregation %s: %v\n", *aggregationPath, err)
+			}
+			opts.DefaultXFF, err = resolveDefaultXFF(*defaultXFF, isFlagSet("default-xff"))
+			if err != nil {
+				usageFatal(err)
+			}
+		}
+		if *timingFlag {
+			opts.Timing = newRunTiming()
+		}
+		if *pushGateway != "" || *scoreFlag {
+			opts.Tally = newCheckTally()
+		}
+		opts.Score = *scoreFlag
+		// print the score once the check is done, before exiting with its result
+		score := func() {
+			if !opts.Score {
+				return
+			}
+			if err := writeCheckScore(os.Stdout, opts.Tally.score(), *format); err != nil {
+				fatalf("failed writing score: %v\n", err)
+			}
+		}
+		// push before exiting with the result, a failed push is an error of its own
+		push := func() {
+			if *pushGateway == "" {
+				return
+			}
+			if err := pushCheckTally(*pushGateway, opts.Tally, time.Now()); err != nil {
+				fatalf("failed to push to %s: %v\n", *pushGateway, err)
+			}
+		}
+		if *tarPath != "" {
+			if flag.NArg() > 0 || *query != "" || *cachePath != "" || *streamFlag {
+				usageFatal("--tar can't be combined with ROOT arguments, --query, --cache or --stream")
+			}
+			var mismatchFound bool
+			mismatchFound, err = checkTarRetentions(*tarPath, schemas, opts)
+			if err != nil {
+				fatal(err)
+			}
+			opts.Timing.print(os.Stderr)
+			score()
+			push()
+			if mismatchFound && *exitOnMismatch {
+				os.Exit(exitMismatch)
+			}
+			return
+		}
+		if *cachePath != "" {
+			var key string
+			key, err = checkCacheKey(*schemasPath, *aggregationPath, opts)
+			if err != nil {
+				fatal(err)
+			}
+			opts.Cache = loadCheckCache(*cachePath, key)
+		}
+		var mismatchFound bool
+		// stop checking on Ctrl-C or SIGTERM instead of leaving a half written report behind
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		mismatchFound, err = checkRetentions(ctx, flag.Args(), schemas, opts)
+		stop()
+		if errors.Is(err, context.Canceled) {
+			fatal("interrupted, check aborted")
 		}
-		// find all .wsp files under path
-		var files []string
-		files, err = findWhisperFiles(path)
 		if err != nil {
-			log.Fatalf("failed walking root %s: %v\n", path, err)
+			fatal(err)
 		}
-		if len(files) == 0 {
-			log.Fatalf("no .wsp files found under %s\n", path)
+		opts.Timing.print(os.Stderr)
+		if opts.Cache != nil {
+			if err = opts.Cache.save(*cachePath); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to write cache %s: %v\n", *cachePath, err)
+			}
 		}
+		score()
+		push()
 
-		// output table header
-		wr := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
-		_, _ = fmt.Fprintln(wr, "status\tmetric\texpected\tactual\tdetail")
-		mismatchFound := false
-
-		for _, f := range files {
-			metric := metricFromPath(path, f)
+		if mismatchFound && *exitOnMismatch {
+			os.Exit(exitMismatch)
+		}
+		return
+	}
 
-			// find first matching schema (top-to-bottom)
-			var matched *Schema
-			for i := range schemas {
-				s := &schemas[i]
-				// If pattern is empty treat as no-match (Graphite typically has pattern)
-				if s.Pattern == nil {
-					continue
-				}
-				if s.Pattern.MatchString(metric) {
-					matched = s
-					break
+	// count mode
+	if *countFlag {
+		if *schemasPath == "" {
+			usageFatal("--schemas is required when --count is used")
+		}
+		var schemas []Schema
+		schemas, err = parseStorageSchemas(*schemasPath, *strictParse)
+		if err != nil {
+			fatalf("failed to parse schemas %s: %v\n", *schemasPath, err)
+		}
+		var timing *runTiming
+		if *timingFlag {
+			timing = newRunTiming()
+		}
+		results := make([]rootSchemaCounts, 0, flag.NArg())
+		var violations []string
+		for _, root := range flag.Args() {
+			res := rootSchemaCounts{Root: root}
+			res.Counts, res.NoMatch, err = countSchemaMatches(root, schemas, walk, timing)
+			if err != nil {
+				fatalf("failed walking root %s: %v\n", root, err)
+			}
+			results = append(results, res)
+			for _, v := range countThresholdViolations(res.Counts, *maxCount, *failOnZero) {
+				if flag.NArg() > 1 {
+					v = root + ": " + v
 				}
+				violations = append(violations, v)
+			}
+		}
+		if err = printSchemaCounts(results, *tableStyle, *showComments); err != nil {
+			fmt.Fprintln(os.Stderr, "error flushing TabWriter")
+		}
+		timing.print(os.Stderr)
+		if len(violations) > 0 {
+			fmt.Println()
+			for _, v := range violations {
+				fmt.Println(v)
 			}
+			os.Exit(exitMismatch)
+		}
+		return
+	}
+
+	// schemas matching nothing
+	if *deadSchemasFlag {
+		if *schemasPath == "" {
+			usageFatal("--schemas is required when --dead-schemas is used")
+		}
+		var schemas []Schema
+		schemas, err = parseStorageSchemas(*schemasPath, *strictParse)
+		if err != nil {
+			fatalf("failed to parse schemas %s: %v\n", *schemasPath, err)
+		}
+		var dead []*Schema
+		dead, err = deadSchemas(flag.Args(), schemas, walk)
+		if err != nil {
+			fatal(err)
+		}
+		if err = printDeadSchemas(dead); err != nil {
+			fmt.Fprintln(os.Stderr, "error flushing TabWriter")
+		}
+		if len(dead) > 0 && *failOnZero {
+			os.Exit(exitMismatch)
+		}
+		return
+	}
 
-			if matched == nil {
-				// no schema matched
-				_, _ = fmt.Fprintf(wr, "NOMATCH\t%s\t-\t-\tno schema matched\n", metric)
-				continue
+	// list the metrics a single schema matched
+	if *members != "" {
+		if *schemasPath == "" {
+			usageFatal("--schemas is required when --members is used")
+		}
+		var schemas []Schema
+		schemas, err = parseStorageSchemas(*schemasPath, *strictParse)
+		if err != nil {
+			fatalf("failed to parse schemas %s: %v\n", *schemasPath, err)
+		}
+		var metrics []string
+		metrics, err = schemaMembers(path, schemas, *members, walk)
+		if err != nil {
+			fatal(err)
+		}
+		for _, m := range metrics {
+			fmt.Println(m)
+		}
+		return
+	}
+
+	// plan mode: dry-run of provisioning the metrics read from stdin
+	if *planFlag {
+		if *schemasPath == "" {
+			usageFatal("--schemas is required when --plan is used")
+		}
+		if *schemasPath == stdinConfig {
+			usageFatal("--schemas - can't be used with --plan, which reads metric names from stdin")
+		}
+		var schemas []Schema
+		schemas, err = parseStorageSchemas(*schemasPath, *strictParse)
+		if err != nil {
+			fatalf("failed to parse schemas %s: %v\n", *schemasPath, err)
+		}
+		var rules []AggregationRule
+		if *aggregationPath != "" {
+			rules, err = parseStorageAggregation(*aggregationPath, *strictParse)
+			if err != nil {
+				fatalf("failed to parse aggregation %s: %v\n", *aggregationPath, err)
 			}
+		}
+		var xff float32
+		xff, err = resolveDefaultXFF(*defaultXFF, isFlagSet("default-xff"))
+		if err != nil {
+			usageFatal(err)
+		}
+		if *reportCreateRate && *createsPerMinute <= 0 {
+			usageFatalf("invalid --creates-per-minute %g: must be positive\n", *createsPerMinute)
+		}
+		var plan []planEntry
+		var noMatch []string
+		plan, noMatch, err = planMetrics(os.Stdin, path, schemas, rules, xff)
+		if err != nil {
+			fatalf("failed reading metrics from stdin: %v\n", err)
+		}
+		if err = printPlan(plan, noMatch); err != nil {
+			fmt.Fprintln(os.Stderr, "error flushing TabWriter")
+		}
+		if *reportCreateRate {
+			printCreateRate(plan, *createsPerMinute)
+		}
+		if len(noMatch) > 0 && *exitOnMismatch {
+			os.Exit(exitMismatch)
+		}
+		return
+	}
+
+	// retention histogram mode
+	if *histogramFlag {
+		var buckets []histogramBucket
+		var unreadable int
+		buckets, unreadable, err = retentionHistogram(path, walk)
+		if err != nil {
+			fatalf("failed walking root %s: %v\n", path, err)
+		}
+		if err = printHistogram("retentions", buckets, unreadable); err != nil {
+			fmt.Fprintln(os.Stderr, "error flushing TabWriter")
+		}
+		return
+	}
 
-			// open whisper file and read retentions
-			var wf *whisper.Whisper
-			wf, err = whisper.Open(f)
+	// aggregation histogram mode
+	if *aggregationHistogramFlag {
+		// the histogram shows what files have, so only warn about the config it was created from
+		if *aggregationPath != "" {
+			var rules []AggregationRule
+			rules, err = parseStorageAggregation(*aggregationPath, *strictParse)
 			if err != nil {
-				_, _ = fmt.Fprintf(wr, "ERROR\t%s\t-\t-\tfailed to open: %v\n", metric, err)
-				mismatchFound = true
-				continue
+				fatalf("failed to parse aggregation %s: %v\n", *aggregationPath, err)
+			}
+			if !hasCatchAll(rules) {
+				fmt.Fprintf(os.Stderr, "warning: %s: %s\n", *aggregationPath, missingCatchAllWarning)
 			}
-			actualSpecs := whisperRetentionsToSpecs(wf.Retentions())
-			err = wf.Close()
+		}
+		var buckets []histogramBucket
+		var unreadable int
+		buckets, unreadable, err = aggregationHistogram(path, walk)
+		if err != nil {
+			fatalf("failed walking root %s: %v\n", path, err)
+		}
+		if err = printHistogram("aggregation\txFilesFactor", buckets, unreadable); err != nil {
+			fmt.Fprintln(os.Stderr, "error flushing TabWriter")
+		}
+		return
+	}
+
+	// lint-names mode
+	if *lintNamesFlag {
+		var found bool
+		found, err = lintMetricNames(path, *suggestFlag, walk)
+		if err != nil {
+			fatalf("failed walking root %s: %v\n", path, err)
+		}
+		if found && *exitOnMismatch {
+			os.Exit(exitMismatch)
+		}
+		return
+	}
+
+	// density mode
+	if *densityFlag {
+		if *format != "table" && *format != "json" {
+			usageFatalf("invalid --format %q: --density supports table, json\n", *format)
+		}
+		var below float64
+		below, err = parsePercent(*densityBelow)
+		if err != nil {
+			usageFatalf("invalid --below: %v\n", err)
+		}
+		var densities []fileDensity
+		densities, err = sparseFiles(path, below, int(time.Now().Unix()), walk)
+		if err != nil {
+			fatalf("failed walking root %s: %v\n", path, err)
+		}
+		if err = printDensities(densities, *format); err != nil {
+			fatalf("failed writing densities: %v\n", err)
+		}
+		return
+	}
+
+	// future mode: points written by collectors whose clock is ahead
+	if *futureFlag {
+		if *format != "table" && *format != "json" {
+			usageFatalf("invalid --format %q: --future supports table, json\n", *format)
+		}
+		var tolerance int
+		tolerance, err = fromHuman(*futureTolerance)
+		if err != nil {
+			usageFatalf("invalid --future-tolerance: %v\n", err)
+		}
+		var files []futureFile
+		files, err = futureFiles(path, tolerance, int(time.Now().Unix()), walk)
+		if err != nil {
+			fatalf("failed walking root %s: %v\n", path, err)
+		}
+		if err = printFutureFiles(files, *format, loc); err != nil {
+			fatalf("failed writing future files: %v\n", err)
+		}
+		return
+	}
+
+	// snapshot a tree, or compare it against an earlier snapshot
+	if *snapshotOut != "" || *snapshotDiff != "" {
+		var snap treeSnapshot
+		snap, err = takeSnapshot(path, walk, time.Now())
+		if err != nil {
+			fatalf("failed walking root %s: %v\n", path, err)
+		}
+		if *snapshotOut != "" {
+			if err = writeSnapshot(*snapshotOut, snap); err != nil {
+				fatalf("failed to write snapshot: %v\n", err)
+			}
+			fmt.Printf("recorded %d metrics to %s\n", len(snap.Metrics), *snapshotOut)
+			return
+		}
+		var before treeSnapshot
+		before, err = readSnapshot(*snapshotDiff)
+		if err != nil {
+			fatalf("failed to read snapshot: %v\n", err)
+		}
+		changes := diffSnapshots(before, snap)
+		if err = printSnapshotChanges(changes); err != nil {
+			fmt.Fprintln(os.Stderr, "error flushing TabWriter")
+		}
+		if len(changes) > 0 && *exitOnMismatch {
+			os.Exit(exitMismatch)
+		}
+		return
+	}
+
+	// verify mode
+	if *verifyFlag {
+		var problems []verifyProblem
+		var counts map[string]int
+		problems, counts, err = verifyTree(path, walk)
+		if err != nil {
+			fatalf("failed walking root %s: %v\n", path, err)
+		}
+		if err = printVerifyReport(problems, counts); err != nil {
+			fmt.Fprintln(os.Stderr, "error flushing TabWriter")
+		}
+		if len(problems) > 0 && *exitOnMismatch {
+			os.Exit(exitMismatch)
+		}
+		return
+	}
+
+	// gap report: the fetched series scanned for runs of nulls
+	if *gapsFlag {
+		if *format != "table" && *format != "json" {
+			usageFatalf("invalid --format %q: --gaps supports table, json\n", *format)
+		}
+		opts := fetchOptions{Archive: max(*archiveIndex, 0)}
+		if *fetchFrom != "" {
+			opts.From, err = fromHuman(*fetchFrom)
 			if err != nil {
-				_, _ = fmt.Fprintf(wr, "ERROR\t%s\t-\t-\tfailed to close: %v\n", path, err)
-				return
+				usageFatalf("invalid --from: %v\n", err)
 			}
+		}
+		var points []seriesPoint
+		var src fetchSource
+		src, points, err = fetchFile(path, opts, int(time.Now().Unix()))
+		if err != nil {
+			fatalf("Error fetching '%s': %v\n", path, err)
+		}
+		if err = printGaps(findGaps(points, src.Spec.SecondsPerPoint), *gapsTop, *format, loc); err != nil {
+			fatalf("failed writing gaps: %v\n", err)
+		}
+		return
+	}
 
-			expectedSpecs := matched.Retentions
+	// fetch datapoints of a single file
+	if *fetchFlag {
+		opts := fetchOptions{Archive: *archiveIndex}
+		if *fetchFrom != "" {
+			opts.From, err = fromHuman(*fetchFrom)
+			if err != nil {
+				usageFatalf("invalid --from: %v\n", err)
+			}
+		}
+		var points []seriesPoint
+		var src fetchSource
+		src, points, err = fetchFile(path, opts, int(time.Now().Unix()))
+		if err != nil {
+			fatalf("Error fetching '%s': %v\n", path, err)
+		}
+		if src.Archive > 0 {
+			fmt.Fprintf(os.Stderr, "note: read from archive %d (%s), values aggregated with %s\n", src.Archive, src.Spec.toHuman(), src.AggregationMethod)
+		}
+		printSeries(points, loc, *nullAs, src, *annotate)
+		return
+	}
 
-			ok := compareSpecsEqual(actualSpecs, expectedSpecs)
-			expectedStr := formatRetentionList(expectedSpecs)
-			actualStr := formatRetentionList(actualSpecs)
-			if ok {
-				_, _ = fmt.Fprintf(wr, "OK\t%s\t%s\t%s\tmatched schema[%s]\n", metric, expectedStr, actualStr, matched.Name)
-			} else {
-				_, _ = fmt.Fprintf(wr, "MISMATCH\t%s\texpected:%s\tgot:%s\tschema[%s]\n", metric, expectedStr, actualStr, matched.Name)
-				mismatchFound = true
+	// create an empty file for a single metric
+	if *touchFlag {
+		if flag.NArg() != 2 {
+			usageFatal("--touch takes exactly two arguments: ROOT METRIC")
+		}
+		if *schemasPath == "" {
+			usageFatal("--schemas is required when --touch is used")
+		}
+		var schemas []Schema
+		schemas, err = parseStorageSchemas(*schemasPath, *strictParse)
+		if err != nil {
+			fatalf("failed to parse schemas %s: %v\n", *schemasPath, err)
+		}
+		var rules []AggregationRule
+		if *aggregationPath != "" {
+			rules, err = parseStorageAggregation(*aggregationPath, *strictParse)
+			if err != nil {
+				fatalf("failed to parse aggregation %s: %v\n", *aggregationPath, err)
 			}
 		}
-		err = wr.Flush()
+		var xff float32
+		xff, err = resolveDefaultXFF(*defaultXFF, isFlagSet("default-xff"))
+		if err != nil {
+			usageFatal(err)
+		}
+		var res touchResult
+		res, err = touchMetric(path, flag.Arg(1), schemas, rules, xff, *dryRun)
 		if err != nil {
-			_, _ = fmt.Fprintln(os.Stderr, "ERROR failed to close TabWriter")
+			fatalf("failed to create %s: %v\n", flag.Arg(1), err)
+		}
+		if *dryRun {
+			if err = printTouchPlan(res); err != nil {
+				fmt.Fprintln(os.Stderr, "error flushing TabWriter")
+			}
 			return
 		}
+		fmt.Printf("created %s (schema [%s] %s, %s, xFilesFactor %g)\n", res.Path, res.Schema.Name, formatRetentionList(res.Schema.Retentions), res.AggregationMethod, res.XFilesFactor)
+		return
+	}
 
-		if mismatchFound && *exitOnMismatch {
-			os.Exit(1)
+	// rename a metric under a root
+	if *renameFlag {
+		opts := renameOptions{Overwrite: *overwrite, DryRun: *dryRun}
+		if *renameMap != "" {
+			if flag.NArg() != 1 {
+				usageFatal("--rename --map takes exactly one argument: ROOT")
+			}
+			var f *os.File
+			f, err = os.Open(*renameMap)
+			if err != nil {
+				fatalf("failed to open map: %v\n", err)
+			}
+			var mappings []renameMapping
+			mappings, err = readRenameMappings(f)
+			_ = f.Close()
+			if err != nil {
+				fatalf("failed to read map %s: %v\n", *renameMap, err)
+			}
+			if renameMetrics(os.Stdout, path, mappings, opts) > 0 {
+				os.Exit(exitError)
+			}
+			return
+		}
+		if flag.NArg() != 3 {
+			usageFatal("--rename takes exactly three arguments: ROOT OLD NEW")
+		}
+		err = renameMetric(os.Stdout, path, flag.Arg(1), flag.Arg(2), opts)
+		if err != nil {
+			fatalf("failed to rename %s: %v\n", flag.Arg(1), err)
 		}
 		return
 	}
 
-	// default: print full info about a single file (table like previous)
-	w, err := whisper.Open(path)
-	if err != nil {
-		log.Fatalf("Error opening '%s': %v\n", path, err)
+	// bulk xFilesFactor change
+	if *setXFF != "" {
+		var xff float64
+		xff, err = strconv.ParseFloat(*setXFF, 32)
+		if err != nil || math.IsNaN(xff) || xff < 0 || xff > 1 {
+			usageFatalf("invalid --set-xff %q: must be a number between 0 and 1\n", *setXFF)
+		}
+		var failed int
+		_, failed, err = setTreeXFilesFactor(os.Stdout, path, *query, float32(xff), *dryRun, walk)
+		if err != nil {
+			fatalf("failed walking root %s: %v\n", path, err)
+		}
+		if failed > 0 {
+			fatalf("%d files could not be updated\n", failed)
+		}
+		return
 	}
-	defer func() {
-		err = w.Close()
+
+	// base interval repair for a single file
+	if *repairFlag {
+		var fixes []baseIntervalFix
+		fixes, err = repairBaseIntervals(os.Stdout, path, *repairApply, int(time.Now().Unix()))
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error closing file '%s': %v\n", path, err)
+			fatalf("Error repairing '%s': %v\n", path, err)
 		}
-	}()
+		if len(fixes) > 0 && !*repairApply && *exitOnMismatch {
+			os.Exit(exitMismatch)
+		}
+		return
+	}
 
-	aggr := w.AggregationMethod().String()
-	xff := w.XFilesFactor()
-	retentions := w.Retentions()
+	// update propagation simulation for a single file
+	if *simulateFlag {
+		now := int(time.Now().Unix())
+		ts := *simulateTimestamp
+		if ts == 0 {
+			ts = now
+		}
+		var steps []propagationStep
+		steps, err = simulateUpdate(path, *simulateValue, ts, now)
+		if err != nil {
+			fatalf("Error simulating update of '%s': %v\n", path, err)
+		}
+		if err = printPropagation(steps, loc); err != nil {
+			fmt.Fprintln(os.Stderr, "error flushing TabWriter")
+		}
+		return
+	}
 
-	fmt.Printf("File: %s\n", path)
-	fmt.Printf("Aggregation: %s\n", aggr)
-	fmt.Printf("xFilesFactor: %g\n", xff)
-	fmt.Println()
+	// resize data-loss estimate for a single file
+	if *estimateLoss != "" {
+		var specs []ArchiveSpec
+		specs, err = parseRetentionList(*estimateLoss)
+		if err != nil {
+			usageFatalf("invalid --estimate-loss retentions: %v\n", err)
+		}
+		var losses []archiveLoss
+		losses, err = estimateResizeLoss(path, specs, int(time.Now().Unix()))
+		if err != nil {
+			fatalf("Error reading '%s': %v\n", path, err)
+		}
+		if err = printResizeLoss(losses); err != nil {
+			fmt.Fprintln(os.Stderr, "error flushing TabWriter")
+		}
+		return
+	}
 
-	wr := tabwriter.NewWriter(os.Stdout, 4, 4, 2, ' ', 0)
-	_, _ = fmt.Fprintln(wr, "archive\tseconds/point\t#points\tretention\tmax age (sec)")
-	for i, r := range retentions {
-		secondsPerPoint := r.SecondsPerPoint()
-		points := r.NumberOfPoints()
-		retentionSecs := secondsPerPoint * points
-		_, _ = fmt.Fprintf(wr, "%d\t%d\t%d\t%s\t%d\n",
-			i,
-			secondsPerPoint,
-			points,
-			toHuman(retentionSecs),
-			retentionSecs,
-		)
-	}
-	err = wr.Flush()
-	if err != nil {
+	// add an archive to a single file
+	if *addArchiveSpec != "" {
+		var spec ArchiveSpec
+		spec, err = parseRetentionSpec(*addArchiveSpec)
+		if err != nil {
+			usageFatalf("invalid --add-archive retention: %v\n", err)
+		}
+		var specs []ArchiveSpec
+		specs, err = addArchive(path, spec, false)
+		if err != nil {
+			fatalf("Error adding archive to '%s': %v\n", path, err)
+		}
+		var losses []archiveLoss
+		losses, err = estimateResizeLoss(path, specs, int(time.Now().Unix()))
+		if err != nil {
+			fatalf("Error reading '%s': %v\n", path, err)
+		}
+		if err = printResizeLoss(losses); err != nil {
+			fmt.Fprintln(os.Stderr, "error flushing TabWriter")
+		}
+		if *dryRun {
+			fmt.Printf("would change retentions to %s\n", formatRetentionList(specs))
+			return
+		}
+		if _, err = addArchive(path, spec, true); err != nil {
+			fatalf("Error adding archive to '%s': %v\n", path, err)
+		}
+		fmt.Printf("changed retentions to %s\n", formatRetentionList(specs))
+		return
+	}
+
+	// summary mode, also used when info is pointed at a directory
+	if st, statErr := os.Stat(path); *summaryFlag || (statErr == nil && st.IsDir()) {
+		if *format != "table" && *format != "json" {
+			usageFatalf("invalid --format %q: --summary supports table, json\n", *format)
+		}
+		var sum treeSummary
+		sum, err = summarizeTree(path, walk)
+		if err != nil {
+			fatalf("failed walking root %s: %v\n", path, err)
+		}
+		if err = printTreeSummary(sum, *format); err != nil {
+			fatalf("failed writing summary: %v\n", err)
+		}
+		return
+	}
+
+	// physical archive layout of a single file
+	if *rawOffsets {
+		if err = printArchiveLayout(path); err != nil {
+			fatalf("Error reading '%s': %v\n", path, err)
+		}
+		return
+	}
+
+	// default: print full info about a single file (table like previous), read from the
+	// --tar archive when given or downloaded first when it is a URL
+	var info *fileInfo
+	var st os.FileInfo
+	if *tarPath != "" {
+		var w *whisper.Whisper
+		var tarHeader *tar.Header
+		w, tarHeader, err = openTarWhisper(*tarPath, path)
+		if err != nil {
+			fatalf("Error opening '%s': %v\n", path, err)
+		}
+		info = &fileInfo{Path: path, fileAttrs: whisperAttrs(w)}
+		if err = w.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error closing file '%s': %v\n", path, err)
+		}
+		if *fileStats {
+			st = tarHeader.FileInfo()
+		}
+	} else if isRemoteWhisper(path) {
+		info, st, err = readRemoteFileInfo(path, *cacheDir, *maxDownload, *fileStats)
+		if err != nil {
+			fatalf("Error reading '%s': %v\n", path, err)
+		}
+	} else {
+		info, err = readFileInfo(path)
+		if err != nil {
+			fatalf("Error opening '%s': %v\n", path, err)
+		}
+		if *fileStats {
+			st, err = os.Stat(path)
+			if err != nil {
+				fatalf("Error reading '%s': %v\n", path, err)
+			}
+		}
+	}
+	if err = printFileInfo(os.Stdout, info, st, loc, *pointsFlag, *tableStyle); err != nil {
 		fmt.Fprintln(os.Stderr, "error flushing TabWriter")
 	}
 }
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// syntheticSchemas returns a storage-schemas.conf with n sections, each with a comment block,
+// a regex pattern and a two archive retention, followed by a catch-all.
+func syntheticSchemas(n int) []byte {
+	var b bytes.Buffer
+	for i := range n {
+		fmt.Fprintf(&b, "# owner: team-%d\n# generated\n[app%d]\npattern = ^apps\\.app%d\\.\nretentions = 10s:6h, 1m:30d\n\n", i%10, i, i)
+	}
+	b.WriteString("[default]\npattern = .*\nretentions = 1m:7d\n")
+	return b.Bytes()
+}
+
+// syntheticTree creates dirs*files empty .wsp files under a temporary root, plus one non-.wsp
+// file per directory, and returns the root. findWhisperFiles doesn't open them.
+func syntheticTree(b testing.TB, dirs, files int) string {
+	b.Helper()
+	root := b.TempDir()
+	for d := range dirs {
+		dir := filepath.Join(root, "servers", fmt.Sprintf("host%03d", d))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			b.Fatal(err)
+		}
+		for f := range files {
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("metric%03d.wsp", f)), nil, 0o644); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestFindWhisperFilesSorted(t *testing.T) {
+	root := t.TempDir()
+	// the walk visits a/ before a.b/, but '.' sorts before '/'
+	for _, name := range []string{"a/z.wsp", "a.b/c.wsp", "a/b/c.wsp", "b.wsp", "A.wsp", "a/y.txt"} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := findWhisperFiles(root, walkOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 5 {
+		t.Errorf("found %d files, want 5: %v", len(files), files)
+	}
+	if !slices.IsSorted(files) {
+		t.Errorf("files not sorted: %v", files)
+	}
+	again, err := findWhisperFiles(root, walkOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(files, again) {
+		t.Errorf("second walk returned %v, first %v", again, files)
+	}
+}
+
+func BenchmarkReadStorageSchemas(b *testing.B) {
+	data := syntheticSchemas(500)
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := readStorageSchemas(bytes.NewReader(data), false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMatchSchema(b *testing.B) {
+	schemas, err := readStorageSchemas(bytes.NewReader(syntheticSchemas(500)), false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, bm := range []struct{ name, metric string }{
+		{"first", "apps.app0.requests"},
+		{"middle", "apps.app250.requests"},
+		{"catch-all", "servers.web01.cpu"},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				if matchSchema(schemas, bm.metric) == nil {
+					b.Fatal("no match")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMetricName(b *testing.B) {
+	walk := walkOptions{}
+	root := "/var/lib/graphite/whisper"
+	file := root + "/servers/web01/cpu/user.wsp"
+	b.ReportAllocs()
+	for b.Loop() {
+		walk.metricName(root, file)
+	}
+}
+
+func BenchmarkFindWhisperFiles(b *testing.B) {
+	root := syntheticTree(b, 50, 40)
+	b.ReportAllocs()
+	for b.Loop() {
+		files, err := findWhisperFiles(root, walkOptions{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(files) != 50*40 {
+			b.Fatalf("found %d files, want %d", len(files), 50*40)
+		}
+	}
+}
+
+func TestParseRetentionSpecRejectsNonPositive(t *testing.T) {
+	for _, spec := range []string{"0s:1d", "-10s:1d", "0:1d", "1m:0", "1m:-5", "1m:0s"} {
+		if got, err := parseRetentionSpec(spec); err == nil {
+			t.Errorf("parseRetentionSpec(%q) = %+v, want error", spec, got)
+		}
+	}
+	for _, spec := range []string{"10s:1d", "1m:1440", "1h:2y"} {
+		if _, err := parseRetentionSpec(spec); err != nil {
+			t.Errorf("parseRetentionSpec(%q): %v", spec, err)
+		}
+	}
+}
+
+func TestArchiveSpecPointsZeroResolution(t *testing.T) {
+	for _, spec := range []ArchiveSpec{{SecondsPerPoint: 0, RetentionSecs: 86400}, {SecondsPerPoint: -10, RetentionSecs: 86400}} {
+		if got := spec.points(); got != 0 {
+			t.Errorf("%+v.points() = %d, want 0", spec, got)
+		}
+		// must not panic either
+		_ = spec.normalized()
+	}
+}
+
+func TestArchiveSpecPointsAndNormalized(t *testing.T) {
+	tests := []struct {
+		spec       string
+		points     int
+		normalized string
+	}{
+		{"10s:6h", 2160, "10s:6h"},
+		{"1m:1d", 1440, "1m:1d"},
+		{"7s:1m", 8, "7s:56s"}, // 60 isn't a multiple of 7
+		{"1h:90m", 1, "1h:1h"},
+		{"1m:1440", 1440, "1m:1d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			spec, err := parseRetentionSpec(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := spec.points(); got != tt.points {
+				t.Errorf("points() = %d, want %d", got, tt.points)
+			}
+			if got := spec.normalized().toHuman(); got != tt.normalized {
+				t.Errorf("normalized() = %s, want %s", got, tt.normalized)
+			}
+		})
+	}
+}
+
+func TestMaxRetention(t *testing.T) {
+	tests := []struct {
+		retentions string
+		want       int
+	}{
+		{"10s:6h", 6 * 3600},
+		{"10s:6h,1m:7d,1h:2y", 2 * 31536000},
+		{"1h:1y,1m:7d", 31536000}, // out of order
+	}
+	for _, tt := range tests {
+		specs, err := parseRetentionList(tt.retentions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := maxRetention(specs); got != tt.want {
+			t.Errorf("maxRetention(%s) = %d, want %d", tt.retentions, got, tt.want)
+		}
+	}
+	if got := maxRetention(nil); got != 0 {
+		t.Errorf("maxRetention(nil) = %d, want 0", got)
+	}
+}
+
+func TestSpecsEqualNormalized(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"60s:1d", "1m:24h", true},
+		{"1m:1d,1h:30d", "60s:1440,3600s:720", true},
+		{"7s:1m", "7s:56s", true}, // both keep 8 points
+		{"7s:1m", "7s:63s", false},
+		{"1m:1d", "1m:2d", false},
+		{"1m:1d", "1m:1d,1h:30d", false},
+		{"1m:1d,1h:30d", "1h:30d,1m:1d", false}, // order matters
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+" vs "+tt.b, func(t *testing.T) {
+			a, err := parseRetentionList(tt.a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := parseRetentionList(tt.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := specsEqualNormalized(a, b); got != tt.want {
+				t.Errorf("specsEqualNormalized = %t, want %t", got, tt.want)
+			}
+			if got := specsEqualNormalized(b, a); got != tt.want {
+				t.Errorf("specsEqualNormalized, swapped = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
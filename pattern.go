@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+)
+
+// readMetricNames reads newline separated metric names from r, skipping blank lines and
+// # comments.
+func readMetricNames(r io.Reader) ([]string, error) {
+	var out []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		metric := strings.TrimSpace(scanner.Text())
+		if metric == "" || strings.HasPrefix(metric, "#") {
+			continue
+		}
+		out = append(out, metric)
+	}
+	return out, scanner.Err()
+}
+
+// testPattern prints whether re matches each of metrics and returns how many matched.
+func testPattern(re *regexp.Regexp, metrics []string) (int, error) {
+	wr := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(wr, "status\tmetric")
+	matched := 0
+	for _, m := range metrics {
+		status := "NOMATCH"
+		if re.MatchString(m) {
+			status = "MATCH"
+			matched++
+		}
+		_, _ = fmt.Fprintf(wr, "%s\t%s\n", status, m)
+	}
+	return matched, wr.Flush()
+}
+
+// printSchemaMatch prints the schema metric would be stored with (first match wins) and
+// returns false when no schema matches.
+func printSchemaMatch(schemas []Schema, metric string) (bool, error) {
+	wr := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(wr, "metric\tschema\tline\tpattern\tretentions")
+	matched := matchSchema(schemas, metric)
+	if matched == nil {
+		_, _ = fmt.Fprintf(wr, "%s\tNOMATCH\t-\t-\t-\n", metric)
+	} else {
+		_, _ = fmt.Fprintf(wr, "%s\t[%s]\t%d\t%s\t%s\n", metric, matched.Name, matched.LineNo, matched.PatternRaw, formatRetentionList(matched.Retentions))
+	}
+	return matched != nil, wr.Flush()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	whisper "github.com/go-graphite/go-whisper"
+)
+
+// planEntry describes the file carbon would create for one metric.
+type planEntry struct {
+	Metric            string
+	Schema            *Schema
+	AggregationMethod whisper.AggregationMethod
+	XFilesFactor      float32
+	Path              string
+	Exists            bool // the file is already there, carbon won't create it
+}
+
+// planMetrics reads newline separated metric names from r and resolves, for each, the schema
+// and aggregation settings it would be created with and its path under root. Nothing is
+// created. Metrics no schema matches are returned separately in input order.
+func planMetrics(r io.Reader, root string, schemas []Schema, rules []AggregationRule, defaultXFF float32) ([]planEntry, []string, error) {
+	metrics, err := readMetricNames(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	var plan []planEntry
+	var noMatch []string
+	for _, metric := range metrics {
+		matched := matchSchema(schemas, metric)
+		if matched == nil {
+			noMatch = append(noMatch, metric)
+			continue
+		}
+		method, xff := resolveAggregation(rules, metric, defaultXFF)
+		plan = append(plan, planEntry{
+			Metric:            metric,
+			Schema:            matched,
+			AggregationMethod: method,
+			XFilesFactor:      xff,
+			Path:              pathFromMetric(root, metric),
+		})
+		if _, err := os.Stat(plan[len(plan)-1].Path); err == nil {
+			plan[len(plan)-1].Exists = true
+		}
+	}
+	return plan, noMatch, nil
+}
+
+// printPlan renders the result of planMetrics, listing unmatched metrics after the table.
+func printPlan(plan []planEntry, noMatch []string) error {
+	wr := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(wr, "metric\tschema\tretentions\taggregation\txFilesFactor\tpath")
+	for _, p := range plan {
+		_, _ = fmt.Fprintf(wr, "%s\t[%s]\t%s\t%s\t%g\t%s\n", p.Metric, p.Schema.Name, formatRetentionList(p.Schema.Retentions), p.AggregationMethod, p.XFilesFactor, p.Path)
+	}
+	if err := wr.Flush(); err != nil {
+		return err
+	}
+	if len(noMatch) > 0 {
+		fmt.Printf("\nNOMATCH (%d metrics, no schema matched):\n", len(noMatch))
+		for _, m := range noMatch {
+			fmt.Printf("  %s\n", m)
+		}
+	}
+	return nil
+}
+
+// carbonMaxCreatesPerMinute is carbon's default MAX_CREATES_PER_MINUTE.
+const carbonMaxCreatesPerMinute = 50
+
+// printCreateRate reports how many files of plan don't exist yet and how long carbon needs to
+// create them when limited to perMinute creates per minute. Datapoints of metrics waiting for
+// their file are held in carbon's cache (or dropped once it is full) until then.
+func printCreateRate(plan []planEntry, perMinute float64) {
+	missing := 0
+	for _, p := range plan {
+		if !p.Exists {
+			missing++
+		}
+	}
+	d := time.Duration(float64(missing) / perMinute * float64(time.Minute))
+	fmt.Printf("\n%d of %d files don't exist yet: at %g creates per minute carbon needs about %s to create them\n", missing, len(plan), perMinute, d.Round(time.Second))
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// checkTally counts --check-retention results per status for --push-gateway and --score.
+// Workers add to it concurrently; add is a no-op on a nil *checkTally.
+type checkTally struct {
+	mu     sync.Mutex
+	counts map[string]int
+	// warned counts the MISMATCH and FINER results graded severityWarn
+	warned int
+}
+
+func newCheckTally() *checkTally {
+	// statuses without files are still reported, as 0
+	return &checkTally{counts: map[string]int{"OK": 0, "MISMATCH": 0, "FINER": 0, "NOMATCH": 0, "ERROR": 0}}
+}
+
+// add counts one result.
+func (t *checkTally) add(r checkResult) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.counts[r.Status]++
+	if r.Severity == severityWarn {
+		t.warned++
+	}
+}
+
+// writeMetrics renders the tally in the Prometheus text exposition format: files per status,
+// the total and the time of the run.
+func (t *checkTally) writeMetrics(w io.Writer, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	statuses := make([]string, 0, len(t.counts))
+	total := 0
+	for s, n := range t.counts {
+		statuses = append(statuses, s)
+		total += n
+	}
+	sort.Strings(statuses)
+
+	_, _ = fmt.Fprintln(w, "# HELP whisper_tools_check_files Whisper files per --check-retention status.")
+	_, _ = fmt.Fprintln(w, "# TYPE whisper_tools_check_files gauge")
+	for _, s := range statuses {
+		_, _ = fmt.Fprintf(w, "whisper_tools_check_files{status=%q} %d\n", s, t.counts[s])
+	}
+	_, _ = fmt.Fprintln(w, "# HELP whisper_tools_check_files_total Whisper files checked.")
+	_, _ = fmt.Fprintln(w, "# TYPE whisper_tools_check_files_total gauge")
+	_, _ = fmt.Fprintf(w, "whisper_tools_check_files_total %d\n", total)
+	_, _ = fmt.Fprintln(w, "# HELP whisper_tools_check_last_run_timestamp_seconds Unix time the check finished.")
+	_, _ = fmt.Fprintln(w, "# TYPE whisper_tools_check_last_run_timestamp_seconds gauge")
+	_, _ = fmt.Fprintf(w, "whisper_tools_check_last_run_timestamp_seconds %d\n", now.Unix())
+}
+
+// pushTimeout bounds the request to the Pushgateway, so a hanging gateway can't stall a cron job.
+const pushTimeout = 10 * time.Second
+
+// pushCheckTally sends the tally to the Pushgateway grouping URL, e.g.
+// http://pushgw:9091/metrics/job/whisper-tools. It uses PUT, replacing all metrics previously
+// pushed to the group, so statuses that disappeared don't linger.
+func pushCheckTally(url string, t *checkTally, now time.Time) error {
+	var body bytes.Buffer
+	t.writeMetrics(&body, now)
+	req, err := http.NewRequest(http.MethodPut, url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	client := &http.Client{Timeout: pushTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
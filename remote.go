@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultMaxDownload is the default of --max-download, far above any sane whisper file.
+const defaultMaxDownload = 1 << 30
+
+// downloadTimeout bounds fetching a remote whisper file, including reading its body.
+const downloadTimeout = 5 * time.Minute
+
+// isRemoteWhisper reports whether arg is an http(s) URL rather than a local path.
+func isRemoteWhisper(arg string) bool {
+	return strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://")
+}
+
+// remoteCachePath is where a download of rawURL is kept in cacheDir: a hash of the URL, so
+// different hosts serving the same path don't collide, followed by the file name for humans.
+func remoteCachePath(cacheDir, rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	name := "remote.wsp"
+	if u, err := url.Parse(rawURL); err == nil && path.Base(u.Path) != "/" && path.Base(u.Path) != "." {
+		name = path.Base(u.Path)
+	}
+	return filepath.Join(cacheDir, hex.EncodeToString(sum[:8])+"-"+name)
+}
+
+// fetchRemoteWhisper downloads the whisper file at rawURL to a local file and returns its path.
+// Downloads larger than maxSize bytes are aborted. With cacheDir the file is kept there and an
+// earlier download is reused without contacting the server; otherwise it goes to a temporary
+// file, which cleanup removes. cleanup is never nil.
+func fetchRemoteWhisper(rawURL, cacheDir string, maxSize int64) (local string, cleanup func(), err error) {
+	cleanup = func() {}
+	dir := cacheDir
+	if cacheDir != "" {
+		local = remoteCachePath(cacheDir, rawURL)
+		if _, err := os.Stat(local); err == nil {
+			return local, cleanup, nil
+		}
+		if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+			return "", cleanup, err
+		}
+	}
+
+	client := &http.Client{Timeout: downloadTimeout}
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return "", cleanup, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", cleanup, fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	if resp.ContentLength > maxSize {
+		return "", cleanup, fmt.Errorf("GET %s: %s exceeds --max-download %s", rawURL, humanBytes(resp.ContentLength), humanBytes(maxSize))
+	}
+
+	// into a temporary file first, so an aborted download never ends up in the cache
+	tmp, err := os.CreateTemp(dir, "whisper-tools-*.wsp")
+	if err != nil {
+		return "", cleanup, err
+	}
+	remove := func() { _ = os.Remove(tmp.Name()) }
+	n, err := io.Copy(tmp, io.LimitReader(resp.Body, maxSize+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n > maxSize {
+		err = fmt.Errorf("GET %s: body exceeds --max-download %s", rawURL, humanBytes(maxSize))
+	}
+	if err != nil {
+		remove()
+		return "", cleanup, err
+	}
+	if cacheDir == "" {
+		return tmp.Name(), remove, nil
+	}
+	if err := os.Rename(tmp.Name(), local); err != nil {
+		remove()
+		return "", cleanup, err
+	}
+	return local, cleanup, nil
+}
+
+// readRemoteFileInfo downloads the whisper file at rawURL with fetchRemoteWhisper and reads its
+// info, and with stats the os.FileInfo of the download. A temporary download is removed before
+// it returns, also when reading it fails.
+func readRemoteFileInfo(rawURL, cacheDir string, maxSize int64, stats bool) (*fileInfo, os.FileInfo, error) {
+	local, cleanup, err := fetchRemoteWhisper(rawURL, cacheDir, maxSize)
+	defer cleanup()
+	if err != nil {
+		return nil, nil, fmt.Errorf("download failed: %v", err)
+	}
+	info, err := readFileInfo(local)
+	if err != nil {
+		return nil, nil, err
+	}
+	info.Path = rawURL
+	if !stats {
+		return info, nil, nil
+	}
+	st, err := os.Stat(local)
+	if err != nil {
+		return nil, nil, err
+	}
+	return info, st, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	whisper "github.com/go-graphite/go-whisper"
+)
+
+func TestReadRemoteFileInfoRemovesDownload(t *testing.T) {
+	good := filepath.Join(t.TempDir(), "cpu.wsp")
+	writeTestWhisper(t, good, "1m:1d", whisper.Average, 0.5)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken.wsp" {
+			_, _ = w.Write([]byte("<html>not whisper</html>"))
+			return
+		}
+		http.ServeFile(w, r, good)
+	}))
+	defer srv.Close()
+
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	for _, tt := range []struct {
+		path    string
+		wantErr bool
+	}{
+		{"/broken.wsp", true},
+		{"/cpu.wsp", false},
+	} {
+		info, st, err := readRemoteFileInfo(srv.URL+tt.path, "", defaultMaxDownload, true)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, want error %t", tt.path, err, tt.wantErr)
+		}
+		if err == nil && (info.Path != srv.URL+tt.path || formatRetentionList(info.Specs) != "1m:1d" || st == nil) {
+			t.Errorf("%s: got %+v, %v", tt.path, info, st)
+		}
+		if left, _ := os.ReadDir(tmp); len(left) != 0 {
+			t.Errorf("%s: download left behind: %v", tt.path, left)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// renameOptions tweaks renameMetric.
+type renameOptions struct {
+	// Overwrite replaces an existing destination file instead of failing.
+	Overwrite bool
+	// DryRun only reports what would be done.
+	DryRun bool
+}
+
+// renameMetric moves the .wsp file of metric oldName under root to where carbon would store
+// newName, creating parent directories as needed and removing source directories left empty.
+// Every action taken (or that would be taken with DryRun) is written to w.
+func renameMetric(w io.Writer, root, oldName, newName string, opts renameOptions) error {
+	src := pathFromMetric(root, oldName)
+	dst := pathFromMetric(root, newName)
+	if src == dst {
+		return fmt.Errorf("%s and %s map to the same file", oldName, newName)
+	}
+	if _, err := os.Stat(src); err != nil {
+		return err
+	}
+	if _, err := os.Stat(dst); err == nil {
+		if !opts.Overwrite {
+			return fmt.Errorf("destination %s already exists (use --overwrite to replace it)", dst)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	verb := "renamed"
+	if opts.DryRun {
+		verb = "would rename"
+	}
+	if !opts.DryRun {
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		if err := os.Rename(src, dst); err != nil {
+			return err
+		}
+	}
+	_, _ = fmt.Fprintf(w, "%s %s -> %s\n", verb, src, dst)
+
+	if opts.DryRun {
+		return nil
+	}
+	return removeEmptyParents(w, root, filepath.Dir(src))
+}
+
+// removeEmptyParents removes dir and its parents up to (but excluding) root for as long as
+// they are empty.
+func removeEmptyParents(w io.Writer, root, dir string) error {
+	root = filepath.Clean(root)
+	for dir = filepath.Clean(dir); dir != root && len(dir) > len(root); dir = filepath.Dir(dir) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return nil
+		}
+		if err := os.Remove(dir); err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(w, "removed empty directory %s\n", dir)
+	}
+	return nil
+}
+
+// renameMapping is one "old<TAB>new" line of a --map file.
+type renameMapping struct {
+	Old, New string
+	LineNo   int
+}
+
+// readRenameMappings reads tab separated "old new" metric name pairs from r. Blank lines and
+// lines starting with # are skipped.
+func readRenameMappings(r io.Reader) ([]renameMapping, error) {
+	scanner := bufio.NewScanner(r)
+	var out []renameMapping
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 2 || strings.TrimSpace(fields[0]) == "" || strings.TrimSpace(fields[1]) == "" {
+			return nil, fmt.Errorf("line %d: expected \"old<TAB>new\", got %q", lineNo, line)
+		}
+		out = append(out, renameMapping{Old: strings.TrimSpace(fields[0]), New: strings.TrimSpace(fields[1]), LineNo: lineNo})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// renameMetrics applies every mapping with renameMetric, continuing past individual failures.
+// A summary with every failure is written to w at the end. It returns the number of failures.
+func renameMetrics(w io.Writer, root string, mappings []renameMapping, opts renameOptions) int {
+	var failures []string
+	for _, m := range mappings {
+		if err := renameMetric(w, root, m.Old, m.New, opts); err != nil {
+			failures = append(failures, fmt.Sprintf("line %d: %s -> %s: %v", m.LineNo, m.Old, m.New, err))
+		}
+	}
+	_, _ = fmt.Fprintf(w, "\n%d renamed, %d failed\n", len(mappings)-len(failures), len(failures))
+	for _, f := range failures {
+		_, _ = fmt.Fprintln(w, f)
+	}
+	return len(failures)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	whisper "github.com/go-graphite/go-whisper"
+)
+
+// baseIntervalFix describes a corrupt base interval of one archive and its replacement.
+type baseIntervalFix struct {
+	Archive int
+	Old     int // timestamp stored in the first slot
+	New     int // 0 when no plausible timestamp was found to derive it from
+	Reason  string
+	// Slot and SlotTimestamp identify the point the new base interval was derived from.
+	Slot          int
+	SlotTimestamp int
+}
+
+// findBaseIntervalFixes inspects the first slot of every archive, whose timestamp whisper uses
+// as base interval to address all other slots. A base interval in the future or not aligned to
+// the archive's resolution makes reads return nonsense; for those archives the latest plausible
+// timestamp stored in the archive is used to derive the base interval it must have had.
+func findBaseIntervalFixes(r io.ReaderAt, h *whisperHeader, now int) ([]baseIntervalFix, error) {
+	var fixes []baseIntervalFix
+	for i, a := range h.Archives {
+		slots, err := readArchiveSlots(r, a)
+		if err != nil {
+			return nil, fmt.Errorf("archive %d: %v", i, err)
+		}
+		base := slots[0].Timestamp
+		fix := baseIntervalFix{Archive: i, Old: base}
+		switch {
+		case base == 0:
+			continue
+		case base > now+a.SecondsPerPoint:
+			fix.Reason = fmt.Sprintf("base interval %d is in the future", base)
+		case base%a.SecondsPerPoint != 0:
+			fix.Reason = fmt.Sprintf("base interval %d is not a multiple of %ds", base, a.SecondsPerPoint)
+		default:
+			continue
+		}
+		oldest := now - a.Retention()
+		for k := 1; k < len(slots); k++ {
+			ts := slots[k].Timestamp
+			if ts <= oldest || ts > now || ts%a.SecondsPerPoint != 0 || ts <= fix.SlotTimestamp {
+				continue
+			}
+			fix.Slot, fix.SlotTimestamp = k, ts
+			fix.New = ts - k*a.SecondsPerPoint
+		}
+		fixes = append(fixes, fix)
+	}
+	return fixes, nil
+}
+
+// repairBaseIntervals reports the corrupt base intervals of the whisper file at path and, with
+// apply, rewrites them. The value of a rewritten first slot can't be trusted, so it is stored
+// as NaN.
+func repairBaseIntervals(w io.Writer, path string, apply bool, now int) ([]baseIntervalFix, error) {
+	flags := os.O_RDONLY
+	if apply {
+		flags = os.O_RDWR
+	}
+	f, err := os.OpenFile(path, flags, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to close file %s %v\n", path, err)
+		}
+	}()
+
+	h, err := readWhisperHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	fixes, err := findBaseIntervalFixes(f, h, now)
+	if err != nil {
+		return nil, err
+	}
+	if len(fixes) == 0 {
+		_, _ = fmt.Fprintln(w, "no corrupt base intervals found")
+		return nil, nil
+	}
+	for _, fix := range fixes {
+		if fix.New == 0 {
+			_, _ = fmt.Fprintf(w, "archive %d: %s, no plausible timestamp to derive a new one from\n", fix.Archive, fix.Reason)
+			continue
+		}
+		verb := "would set"
+		if apply {
+			verb = "set"
+			buf := make([]byte, whisper.PointSize)
+			binary.BigEndian.PutUint32(buf[0:4], uint32(fix.New))
+			binary.BigEndian.PutUint64(buf[4:12], math.Float64bits(math.NaN()))
+			if _, err := f.WriteAt(buf, h.Archives[fix.Archive].Offset); err != nil {
+				return fixes, fmt.Errorf("archive %d: %v", fix.Archive, err)
+			}
+		}
+		_, _ = fmt.Fprintf(w, "archive %d: %s, %s it to %d (derived from slot %d at %d)\n", fix.Archive, fix.Reason, verb, fix.New, fix.Slot, fix.SlotTimestamp)
+	}
+	return fixes, nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	whisper "github.com/go-graphite/go-whisper"
+)
+
+// archiveLoss describes how many points one archive of a file would lose if the file were
+// resized to a new retention list.
+type archiveLoss struct {
+	Index   int
+	Current ArchiveSpec
+	// Target is the archive with the same resolution in the new retention list, nil when
+	// the new list drops that resolution entirely.
+	Target *ArchiveSpec
+	Points int // non-null points currently stored in the archive
+	Lost   int // non-null points outside the target retention window
+}
+
+// estimateResizeLoss counts, per archive of the whisper file at path, the non-null points
+// that fall outside the window of newSpecs. Archives are paired by resolution; an archive
+// whose resolution is not present in newSpecs loses all of its points.
+func estimateResizeLoss(path string, newSpecs []ArchiveSpec, now int) ([]archiveLoss, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to close file %s %v\n", path, err)
+		}
+	}()
+
+	header, err := readWhisperHeader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]archiveLoss, 0, len(header.Archives))
+	for i, a := range header.Archives {
+		points, err := readArchivePoints(f, a, now)
+		if err != nil {
+			return nil, fmt.Errorf("archive %d: %v", i, err)
+		}
+		loss := archiveLoss{
+			Index:   i,
+			Current: ArchiveSpec{SecondsPerPoint: a.SecondsPerPoint, RetentionSecs: a.Retention()},
+			Points:  len(points),
+		}
+		for j := range newSpecs {
+			if newSpecs[j].SecondsPerPoint == a.SecondsPerPoint {
+				loss.Target = &newSpecs[j]
+				break
+			}
+		}
+		if loss.Target == nil {
+			loss.Lost = len(points)
+		} else {
+			cutoff := now - loss.Target.RetentionSecs
+			for _, p := range points {
+				if p.Timestamp <= cutoff {
+					loss.Lost++
+				}
+			}
+		}
+		out = append(out, loss)
+	}
+	return out, nil
+}
+
+// printResizeLoss renders the result of estimateResizeLoss as a table followed by the total.
+func printResizeLoss(losses []archiveLoss) error {
+	wr := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(wr, "archive\tcurrent\tnew\t#points\tlost")
+	total := 0
+	for _, l := range losses {
+		target := "removed"
+		if l.Target != nil {
+			target = l.Target.toHuman()
+		}
+		_, _ = fmt.Fprintf(wr, "%d\t%s\t%s\t%d\t%d\n", l.Index, l.Current.toHuman(), target, l.Points, l.Lost)
+		total += l.Lost
+	}
+	if err := wr.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("\nTotal points lost: %d\n", total)
+	return nil
+}
+
+// insertArchiveSpec returns specs with spec inserted where its resolution belongs. The result
+// must still be a list whisper accepts, see checkRetentionOrder and checkArchiveList: a new
+// finest archive has to divide the resolution of the current finest one evenly.
+func insertArchiveSpec(specs []ArchiveSpec, spec ArchiveSpec) ([]ArchiveSpec, error) {
+	at := 0
+	for at < len(specs) && specs[at].SecondsPerPoint < spec.SecondsPerPoint {
+		at++
+	}
+	out := make([]ArchiveSpec, 0, len(specs)+1)
+	out = append(append(append(out, specs[:at]...), spec), specs[at:]...)
+	if issues := checkRetentionOrder("", []Schema{{Retentions: out}}); len(issues) > 0 {
+		return nil, fmt.Errorf("can't add %s to %s: %s", spec.toHuman(), formatRetentionList(specs), issues[0].Detail)
+	}
+	if err := checkArchiveList(out); err != nil {
+		return nil, fmt.Errorf("can't add %s to %s: %v", spec.toHuman(), formatRetentionList(specs), err)
+	}
+	return out, nil
+}
+
+// addArchive adds an empty archive for spec to the whisper file at path, see
+// insertArchiveSpec, and returns the new retention list. The existing archives are copied
+// unchanged, so no point is lost; the new archive fills as points are written. The file is
+// replaced atomically. Without apply the file is only read.
+func addArchive(path string, spec ArchiveSpec, apply bool) ([]ArchiveSpec, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to close file %s %v\n", path, err)
+		}
+	}()
+
+	h, err := readWhisperHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	current := make([]ArchiveSpec, 0, len(h.Archives))
+	for _, a := range h.Archives {
+		current = append(current, ArchiveSpec{SecondsPerPoint: a.SecondsPerPoint, RetentionSecs: a.Retention()})
+	}
+	specs, err := insertArchiveSpec(current, spec)
+	if err != nil || !apply {
+		return specs, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return specs, replaceWithArchives(path, st.Mode().Perm(), f, h, specs)
+}
+
+// replaceWithArchives writes a whisper file with the header of h and the archives of specs to
+// path, copying the data of every archive of h from r and leaving archives without a match in
+// h empty, then moves it over path.
+func replaceWithArchives(path string, perm os.FileMode, r io.ReaderAt, h *whisperHeader, specs []ArchiveSpec) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if err = writeWithArchives(tmp, r, h, specs); err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// writeWithArchives writes the file of replaceWithArchives to w, which is expected to be empty.
+func writeWithArchives(w *os.File, r io.ReaderAt, h *whisperHeader, specs []ArchiveSpec) error {
+	meta := make([]byte, whisper.MetadataSize+len(specs)*whisper.ArchiveInfoSize)
+	binary.BigEndian.PutUint32(meta[0:4], uint32(h.AggregationMethod))
+	binary.BigEndian.PutUint32(meta[4:8], uint32(maxRetention(specs)))
+	binary.BigEndian.PutUint32(meta[8:12], math.Float32bits(h.XFilesFactor))
+	binary.BigEndian.PutUint32(meta[12:16], uint32(len(specs)))
+
+	offset := int64(len(meta))
+	old := 0
+	for i, spec := range specs {
+		b := meta[whisper.MetadataSize+i*whisper.ArchiveInfoSize:]
+		binary.BigEndian.PutUint32(b[0:4], uint32(offset))
+		binary.BigEndian.PutUint32(b[4:8], uint32(spec.SecondsPerPoint))
+		binary.BigEndian.PutUint32(b[8:12], uint32(spec.points()))
+		size := int64(spec.points() * whisper.PointSize)
+		// the archives of h come in the same order, with the new one somewhere in between
+		if old < len(h.Archives) && h.Archives[old].SecondsPerPoint == spec.SecondsPerPoint {
+			data := io.NewSectionReader(r, h.Archives[old].Offset, size)
+			if _, err := io.Copy(io.NewOffsetWriter(w, offset), data); err != nil {
+				return fmt.Errorf("archive %d: %v", old, err)
+			}
+			old++
+		}
+		offset += size
+	}
+	if _, err := w.WriteAt(meta, 0); err != nil {
+		return err
+	}
+	// the new archive is left as a hole, which reads as never written slots
+	return w.Truncate(offset)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	whisper "github.com/go-graphite/go-whisper"
+)
+
+func TestInsertArchiveSpec(t *testing.T) {
+	current, _ := parseRetentionList("1m:1d,1h:30d")
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr string
+	}{
+		{"1s:1h", "1s:1h,1m:1d,1h:30d", ""},
+		{"10m:7d", "1m:1d,10m:7d,1h:30d", ""},
+		{"1d:1y", "1m:1d,1h:30d,1d:1y", ""},
+		{"7s:1h", "", "not a multiple of 7s"},
+		{"1m:7d", "", "1m:1d follows 1m:7d"},
+		{"1s:1d", "", "must cover more time"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			spec, err := parseRetentionSpec(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := insertArchiveSpec(current, spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if formatRetentionList(got) != tt.want {
+				t.Errorf("got %s, want %s", formatRetentionList(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestAddArchiveKeepsData(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cpu.wsp")
+	writeTestWhisper(t, path, "1m:1d,1h:30d", whisper.Sum, 0.3)
+	now := int(time.Now().Unix())
+	w, err := whisper.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 5; i++ {
+		if err := w.Update(float64(i), now-i*60); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = w.Close()
+
+	spec, _ := parseRetentionSpec("10s:1h")
+	if _, err := addArchive(path, spec, false); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := readFileInfo(path); err != nil || formatRetentionList(info.Specs) != "1m:1d,1h:30d" {
+		t.Fatalf("dry run changed the file: %+v, %v", info, err)
+	}
+	if _, err := addArchive(path, spec, true); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := readFileInfo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatRetentionList(info.Specs); got != "10s:1h,1m:1d,1h:30d" || info.AggregationMethod != whisper.Sum || info.XFilesFactor != 0.3 {
+		t.Errorf("got %s, %s, %g, want 10s:1h,1m:1d,1h:30d, sum, 0.3", got, info.AggregationMethod, info.XFilesFactor)
+	}
+	if category, detail := classifyWhisperFile(path); category != verifyOK {
+		t.Errorf("verify: %s: %s", category, detail)
+	}
+	if w, err = whisper.Open(path); err != nil {
+		t.Fatalf("whisper can't open the new file: %v", err)
+	}
+	_ = w.Close()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	h, err := readWhisperHeader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	points, err := readArchivePoints(f, h.Archives[1], now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 5 {
+		t.Errorf("1m archive has %d points, want the 5 written before", len(points))
+	}
+	if empty, err := readArchivePoints(f, h.Archives[0], now); err != nil || len(empty) != 0 {
+		t.Errorf("new archive has %d points, %v, want none", len(empty), err)
+	}
+}
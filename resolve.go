@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// writeResolution explains which sections carbon would apply to metric: the first matching
+// schema with its retentions and the first matching aggregation rule, marking every value
+// that falls back to a default. It returns false when no schema matches.
+func writeResolution(w io.Writer, schemas []Schema, rules []AggregationRule, metric string, defaultXFF float32) bool {
+	_, _ = fmt.Fprintf(w, "Metric: %s\n", metric)
+
+	s := matchSchema(schemas, metric)
+	if s == nil {
+		_, _ = fmt.Fprintln(w, "Schema: NOMATCH")
+	} else {
+		_, _ = fmt.Fprintf(w, "Schema: [%s] (line %d)\n", s.Name, s.LineNo)
+		_, _ = fmt.Fprintf(w, "  pattern: %s (%s)\n", s.PatternRaw, s.MatchType)
+		if s.PatternFlags != "" {
+			_, _ = fmt.Fprintf(w, "  patternFlags: %s\n", s.PatternFlags)
+		}
+		_, _ = fmt.Fprintf(w, "  retentions: %s\n", formatRetentionList(s.Retentions))
+	}
+
+	method, xff := resolveAggregation(rules, metric, defaultXFF)
+	methodSource, xffSource := "default", "default"
+	r := matchAggregationRule(rules, metric)
+	if r == nil {
+		_, _ = fmt.Fprintln(w, "Aggregation: NOMATCH")
+	} else {
+		_, _ = fmt.Fprintf(w, "Aggregation: [%s] (line %d)\n", r.Name, r.LineNo)
+		_, _ = fmt.Fprintf(w, "  pattern: %s (%s)\n", r.PatternRaw, r.MatchType)
+		if r.AggregationMethod != 0 {
+			methodSource = "[" + r.Name + "]"
+		}
+		if r.XFilesFactor != nil {
+			xffSource = "[" + r.Name + "]"
+		}
+	}
+	_, _ = fmt.Fprintf(w, "  aggregationMethod: %s (%s)\n", method, methodSource)
+	_, _ = fmt.Fprintf(w, "  xFilesFactor: %g (%s)\n", xff, xffSource)
+	return s != nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// Weights of the --score formula: a file counts fully when OK, half when its retentions are
+// off but still keep the history its schema asks for, and not at all otherwise.
+const (
+	scoreWeightOK   = 1.0
+	scoreWeightWarn = 0.5
+)
+
+// scoreFormula documents how checkScore.Score is computed, for the JSON output.
+const scoreFormula = "100 * (ok + 0.5 * warn) / files"
+
+// checkScore is the health score of a --check-retention run with its components, so it can be
+// recomputed or broken down.
+type checkScore struct {
+	Score   float64 `json:"score"` // percent, see scoreFormula
+	Formula string  `json:"formula"`
+	Files   int     `json:"files"`
+	OK      int     `json:"ok"`
+	Warn    int     `json:"warn"`    // MISMATCH or FINER keeping at least the expected history
+	Failing int     `json:"failing"` // MISMATCH or FINER losing history
+	NoMatch int     `json:"nomatch"` // not covered by any schema
+	Errors  int     `json:"errors"`  // unreadable files
+}
+
+// score computes the health score of the results counted so far. A run without files scores
+// 100, there is nothing wrong with it.
+func (t *checkTally) score() checkScore {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := checkScore{
+		Formula: scoreFormula,
+		OK:      t.counts["OK"],
+		Warn:    t.warned,
+		Failing: t.counts["MISMATCH"] + t.counts["FINER"] - t.warned,
+		NoMatch: t.counts["NOMATCH"],
+		Errors:  t.counts["ERROR"],
+	}
+	for _, n := range t.counts {
+		s.Files += n
+	}
+	s.Score = 100
+	if s.Files > 0 {
+		s.Score = 100 * (scoreWeightOK*float64(s.OK) + scoreWeightWarn*float64(s.Warn)) / float64(s.Files)
+	}
+	return s
+}
+
+// writeCheckScore writes s as a small table or, with format json, as a JSON object.
+func writeCheckScore(w io.Writer, s checkScore, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	}
+	wr := tabwriter.NewWriter(w, 2, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintf(wr, "score\t%.1f%%\t%s\n", s.Score, s.Formula)
+	_, _ = fmt.Fprintf(wr, "files\t%d\t\n", s.Files)
+	_, _ = fmt.Fprintf(wr, "ok\t%d\t\n", s.OK)
+	_, _ = fmt.Fprintf(wr, "warn\t%d\tMISMATCH or FINER keeping at least the expected history\n", s.Warn)
+	_, _ = fmt.Fprintf(wr, "failing\t%d\tMISMATCH or FINER losing history\n", s.Failing)
+	_, _ = fmt.Fprintf(wr, "nomatch\t%d\t\n", s.NoMatch)
+	_, _ = fmt.Fprintf(wr, "errors\t%d\t\n", s.Errors)
+	return wr.Flush()
+}
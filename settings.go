@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// configFileName is looked up in the working directory, then in $HOME, to provide defaults
+// for command-line flags.
+const configFileName = ".whisper-tools.conf"
+
+// configFileFlags are the flags a config file may set: defaults for the paths, output and
+// thresholds of the other flags. Modes, flags that write or send anything and filters that
+// silently narrow a run are left out, a config file picked up from the working directory
+// must not change what a command does.
+var configFileFlags = []string{
+	"schemas", "aggregation", "strict-parse", "max-config-line",
+	"format", "table-style", "timezone", "points", "show-path", "show-comments", "null-as",
+	"workers", "file-timeout", "timing", "verbose", "explain",
+	"extension", "max-depth", "dedupe-files", "no-normalize", "allow-empty",
+	"exit-on-mismatch", "fail-on", "report-finer", "tolerance", "default-xff",
+	"allowed-aggregations", "min-resolution", "max-retention", "carbon-interval", "warn-suspicious",
+	"cache-dir", "max-download", "creates-per-minute",
+}
+
+// errConfigFlagNotAllowed is returned by applyConfigFile for a flag not in configFileFlags.
+var errConfigFlagNotAllowed = errors.New("flag can't be set in a config file")
+
+// errConfigUnknownFlag is returned by applyConfigFile for a name that isn't a flag at all.
+var errConfigUnknownFlag = errors.New("unknown flag")
+
+// findConfigFile returns the first existing configFileName in the working directory or
+// $HOME, or "" if there is none.
+func findConfigFile() string {
+	var dirs []string
+	if wd, err := os.Getwd(); err == nil {
+		dirs = append(dirs, wd)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, home)
+	}
+	for _, d := range dirs {
+		p := filepath.Join(d, configFileName)
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// applyConfigFile reads "flag = value" lines from path and sets every named flag that was
+// not given on the command line, so the command line always wins. A repeatable flag such as
+// extension may be given on several lines. Lines starting with # are comments; unknown flag
+// names are an error, as are flags not in configFileFlags.
+func applyConfigFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("config file %s does not exist", path)
+		}
+		return err
+	}
+
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to close file %s %v\n", path, err)
+		}
+	}()
+
+	// taken before setting anything, flag.Visit also lists the flags set from the file
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, val, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: expected flag = value", path, lineNo)
+		}
+		key = strings.TrimPrefix(strings.TrimSpace(key), "--")
+		val = strings.TrimSpace(val)
+		if flag.Lookup(key) == nil {
+			return fmt.Errorf("%s:%d: %w %q", path, lineNo, errConfigUnknownFlag, key)
+		}
+		if !slices.Contains(configFileFlags, key) {
+			return fmt.Errorf("%s:%d: %w: %s", path, lineNo, errConfigFlagNotAllowed, key)
+		}
+		if given[key] {
+			continue
+		}
+		if err := flag.Set(key, val); err != nil {
+			return fmt.Errorf("%s:%d: invalid value for %s: %v", path, lineNo, key, err)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	whisper "github.com/go-graphite/go-whisper"
+)
+
+func TestConfigFileFlags(t *testing.T) {
+	root := t.TempDir()
+	writeTestWhisper(t, filepath.Join(root, "servers", "cpu.wsp"), "1m:1d", whisper.Average, 0.5)
+	writeTestWhisper(t, filepath.Join(root, "servers", "mem.bak"), "1m:7d", whisper.Average, 0.5)
+	schemas := writeTestFile(t, "storage-schemas.conf", "[servers]\npattern = ^servers\\.\nretentions = 1m:1d\n")
+
+	tests := []struct {
+		name   string
+		config string
+		args   []string
+		want   int
+	}{
+		{"default flag", "schemas = " + schemas + "\nworkers = 2\n", []string{"--check-retention", root}, exitOK},
+		{"mode flag", "schemas = " + schemas + "\ncheck-retention = true\n", []string{root}, exitUsage},
+		{"destructive flag", "set-xff = 0\n", []string{"--check-retention", "--schemas=" + schemas, root}, exitUsage},
+		{"outward flag", "push-gateway = http://localhost:9091\n", []string{"--check-retention", "--schemas=" + schemas, root}, exitUsage},
+		{"unknown flag", "no-such-flag = 1\n", []string{"--check-retention", "--schemas=" + schemas, root}, exitUsage},
+		{"malformed line", "schemas\n", []string{"--check-retention", "--schemas=" + schemas, root}, exitError},
+		// the .bak file doesn't match its schema, so the second line must not be dropped
+		{"repeated flag", "schemas = " + schemas + "\nextension = .wsp\nextension = .bak\n", []string{"--check-retention", root}, exitMismatch},
+		{"repeated flag given on the command line", "schemas = " + schemas + "\nextension = .wsp\nextension = .bak\n", []string{"--check-retention", "--extension=.wsp", root}, exitOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, configFileName), []byte(tt.config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if got := runToolIn(t, dir, tt.args...); got != tt.want {
+				t.Errorf("exit code %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
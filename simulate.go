@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	whisper "github.com/go-graphite/go-whisper"
+)
+
+// propagationStep is what an update would do to one archive.
+type propagationStep struct {
+	Archive  int
+	Spec     ArchiveSpec
+	Interval int // start of the slot written
+	Known    int // known points of the higher archive covering the slot, 0 for the written archive
+	Total    int // points of the higher archive covering the slot
+	Value    float64
+	// Written is false for the first archive whose xFilesFactor isn't met, where propagation stops.
+	Written bool
+}
+
+// aggregateValues aggregates values the way whisper does when propagating to a lower archive.
+func aggregateValues(method whisper.AggregationMethod, values []float64) (float64, error) {
+	switch method {
+	case whisper.Average, whisper.Sum:
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		if method == whisper.Average {
+			return sum / float64(len(values)), nil
+		}
+		return sum, nil
+	case whisper.First:
+		return values[0], nil
+	case whisper.Last:
+		return values[len(values)-1], nil
+	case whisper.Max, whisper.Min:
+		out := values[0]
+		for _, v := range values[1:] {
+			if (method == whisper.Max && v > out) || (method == whisper.Min && v < out) {
+				out = v
+			}
+		}
+		return out, nil
+	}
+	return 0, fmt.Errorf("aggregation method %s is not supported", method)
+}
+
+// slotIndex returns the slot of an archive that holds interval, given the archive's slots. Like
+// whisper, slots are addressed relative to the timestamp stored in the first slot.
+func slotIndex(slots []dataPoint, a archiveHeader, interval int) int {
+	base := slots[0].Timestamp
+	if base == 0 {
+		return 0
+	}
+	idx := (interval - base) / a.SecondsPerPoint % a.Points
+	if idx < 0 {
+		idx += a.Points
+	}
+	return idx
+}
+
+// simulateUpdate computes which archives of the whisper file at path an update of value at
+// timestamp would write, following whisper's propagation rules with the file's aggregation
+// method and xFilesFactor. Nothing is written to the file.
+func simulateUpdate(path string, value float64, timestamp, now int) ([]propagationStep, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to close file %s %v\n", path, err)
+		}
+	}()
+
+	h, err := readWhisperHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	diff := now - timestamp
+	if diff < 0 || diff >= h.MaxRetention {
+		return nil, fmt.Errorf("timestamp %d is not covered by any archive", timestamp)
+	}
+	first := 0
+	for first < len(h.Archives) && h.Archives[first].Retention() < diff {
+		first++
+	}
+
+	higher := h.Archives[first]
+	higherSlots, err := readArchiveSlots(f, higher)
+	if err != nil {
+		return nil, fmt.Errorf("archive %d: %v", first, err)
+	}
+	interval := timestamp - timestamp%higher.SecondsPerPoint
+	// apply the update to the in-memory copy only
+	higherSlots[slotIndex(higherSlots, higher, interval)] = dataPoint{Timestamp: interval, Value: value}
+	steps := []propagationStep{{
+		Archive:  first,
+		Spec:     ArchiveSpec{SecondsPerPoint: higher.SecondsPerPoint, RetentionSecs: higher.Retention()},
+		Interval: interval,
+		Value:    value,
+		Written:  true,
+	}}
+
+	for i := first + 1; i < len(h.Archives); i++ {
+		lower := h.Archives[i]
+		step := propagationStep{
+			Archive:  i,
+			Spec:     ArchiveSpec{SecondsPerPoint: lower.SecondsPerPoint, RetentionSecs: lower.Retention()},
+			Interval: interval - interval%lower.SecondsPerPoint,
+			Total:    lower.SecondsPerPoint / higher.SecondsPerPoint,
+		}
+		var known []float64
+		start := slotIndex(higherSlots, higher, step.Interval)
+		for j := 0; j < step.Total; j++ {
+			p := higherSlots[(start+j)%higher.Points]
+			if p.Timestamp == step.Interval+j*higher.SecondsPerPoint {
+				known = append(known, p.Value)
+			}
+		}
+		step.Known = len(known)
+		if step.Known == 0 || float32(step.Known)/float32(step.Total) < h.XFilesFactor {
+			steps = append(steps, step)
+			break
+		}
+		step.Value, err = aggregateValues(h.AggregationMethod, known)
+		if err != nil {
+			return nil, err
+		}
+		step.Written = true
+		steps = append(steps, step)
+
+		lowerSlots, err := readArchiveSlots(f, lower)
+		if err != nil {
+			return nil, fmt.Errorf("archive %d: %v", i, err)
+		}
+		lowerSlots[slotIndex(lowerSlots, lower, step.Interval)] = dataPoint{Timestamp: step.Interval, Value: step.Value}
+		higher, higherSlots, interval = lower, lowerSlots, step.Interval
+	}
+	return steps, nil
+}
+
+// printPropagation renders the result of simulateUpdate as a table.
+func printPropagation(steps []propagationStep, loc *time.Location) error {
+	wr := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(wr, "archive\tretention\tinterval\tknown\tvalue\tresult")
+	for _, s := range steps {
+		known, val, result := "-", "-", "written"
+		if s.Total > 0 {
+			known = fmt.Sprintf("%d/%d", s.Known, s.Total)
+		}
+		if s.Written {
+			val = fmt.Sprint(s.Value)
+			if s.Total > 0 {
+				result = "propagated"
+			}
+		} else {
+			result = "skipped: not enough known points for xFilesFactor"
+		}
+		_, _ = fmt.Fprintf(wr, "%d\t%s\t%s\t%s\t%s\t%s\n", s.Archive, s.Spec.toHuman(), formatTimestamp(s.Interval, loc), known, val, result)
+	}
+	return wr.Flush()
+}
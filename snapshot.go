@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	whisper "github.com/go-graphite/go-whisper"
+)
+
+// snapshotEntry records the settings of one metric's file.
+type snapshotEntry struct {
+	Metric            string  `json:"metric"`
+	Retentions        string  `json:"retentions"`
+	AggregationMethod string  `json:"aggregationMethod"`
+	XFilesFactor      float32 `json:"xFilesFactor"`
+}
+
+// describe renders the settings of e on one line for diffs.
+func (e snapshotEntry) describe() string {
+	return fmt.Sprintf("%s %s xff=%g", e.Retentions, e.AggregationMethod, e.XFilesFactor)
+}
+
+// treeSnapshot is the settings of every metric under a whisper root at one point in time.
+type treeSnapshot struct {
+	Root    string          `json:"root"`
+	Taken   string          `json:"taken"`
+	Metrics []snapshotEntry `json:"metrics"`
+}
+
+// takeSnapshot reads the settings of every .wsp file under root. Unreadable files are reported
+// on stderr and left out.
+func takeSnapshot(root string, walk walkOptions, now time.Time) (treeSnapshot, error) {
+	snap := treeSnapshot{Root: root, Taken: now.UTC().Format(time.RFC3339), Metrics: []snapshotEntry{}}
+	files, err := findWhisperFiles(root, walk)
+	if err != nil {
+		return snap, err
+	}
+	flags := os.O_RDONLY
+	for _, f := range files {
+		w, err := whisper.OpenWithOptions(f, &whisper.Options{OpenFileFlag: &flags})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", f, err)
+			continue
+		}
+		snap.Metrics = append(snap.Metrics, snapshotEntry{
+			Metric:            walk.metricName(root, f),
+			Retentions:        formatRetentionList(whisperRetentionsToSpecs(w.Retentions())),
+			AggregationMethod: w.AggregationMethod().String(),
+			XFilesFactor:      w.XFilesFactor(),
+		})
+		_ = w.Close()
+	}
+	return snap, nil
+}
+
+// writeSnapshot saves snap as JSON to path.
+func writeSnapshot(path string, snap treeSnapshot) error {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// readSnapshot loads a snapshot written by writeSnapshot.
+func readSnapshot(path string) (treeSnapshot, error) {
+	var snap treeSnapshot
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return snap, err
+	}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return snap, fmt.Errorf("invalid snapshot %s: %v", path, err)
+	}
+	return snap, nil
+}
+
+// snapshotChange is a metric that differs between two snapshots.
+type snapshotChange struct {
+	Kind   string // ADDED, REMOVED or CHANGED
+	Metric string
+	Before *snapshotEntry
+	After  *snapshotEntry
+}
+
+// diffSnapshots lists the metrics added, removed or changed from before to after, in metric
+// name order.
+func diffSnapshots(before, after treeSnapshot) []snapshotChange {
+	old := make(map[string]*snapshotEntry, len(before.Metrics))
+	for i := range before.Metrics {
+		old[before.Metrics[i].Metric] = &before.Metrics[i]
+	}
+	var changes []snapshotChange
+	seen := make(map[string]bool, len(after.Metrics))
+	for i := range after.Metrics {
+		cur := &after.Metrics[i]
+		seen[cur.Metric] = true
+		prev, ok := old[cur.Metric]
+		switch {
+		case !ok:
+			changes = append(changes, snapshotChange{Kind: "ADDED", Metric: cur.Metric, After: cur})
+		case *prev != *cur:
+			changes = append(changes, snapshotChange{Kind: "CHANGED", Metric: cur.Metric, Before: prev, After: cur})
+		}
+	}
+	for i := range before.Metrics {
+		prev := &before.Metrics[i]
+		if !seen[prev.Metric] {
+			changes = append(changes, snapshotChange{Kind: "REMOVED", Metric: prev.Metric, Before: prev})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Metric < changes[j].Metric })
+	return changes
+}
+
+// printSnapshotChanges renders the result of diffSnapshots as a table.
+func printSnapshotChanges(changes []snapshotChange) error {
+	if len(changes) == 0 {
+		fmt.Println("no changes")
+		return nil
+	}
+	wr := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(wr, "change\tmetric\tbefore\tafter")
+	for _, c := range changes {
+		before, after := "-", "-"
+		if c.Before != nil {
+			before = c.Before.describe()
+		}
+		if c.After != nil {
+			after = c.After.describe()
+		}
+		_, _ = fmt.Fprintf(wr, "%s\t%s\t%s\t%s\n", c.Kind, c.Metric, before, after)
+	}
+	return wr.Flush()
+}
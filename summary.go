@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// treeSummary is the dashboard-style overview of a whisper tree printed by --summary.
+type treeSummary struct {
+	Root               string            `json:"root"`
+	Files              int               `json:"files"`
+	Unreadable         int               `json:"unreadable"`
+	TotalBytes         int64             `json:"totalBytes"`
+	MinBytes           int64             `json:"minBytes"`
+	MedianBytes        int64             `json:"medianBytes"`
+	MaxBytes           int64             `json:"maxBytes"`
+	Points             int               `json:"points"` // total point capacity of all readable files
+	EmptyFiles         int               `json:"emptyFiles"`
+	Retentions         []histogramBucket `json:"retentions"`
+	AggregationMethods []histogramBucket `json:"aggregationMethods"`
+}
+
+// summarizeTree walks root and aggregates size, retention, aggregation and data presence of
+// every .wsp file under it. Files that can't be read as whisper still count towards the file
+// and size figures.
+func summarizeTree(root string, walk walkOptions) (treeSummary, error) {
+	sum := treeSummary{Root: root}
+	files, err := findWhisperFiles(root, walk)
+	if err != nil {
+		return sum, err
+	}
+
+	now := int(time.Now().Unix())
+	sizes := make([]int64, 0, len(files))
+	retentions := map[string]int{}
+	methods := map[string]int{}
+	for _, f := range files {
+		sum.Files++
+		if st, err := os.Stat(f); err == nil {
+			sizes = append(sizes, st.Size())
+			sum.TotalBytes += st.Size()
+		}
+
+		attrs, hasData, dataErr, err := summarizeFile(f, now)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", f, err)
+			sum.Unreadable++
+			continue
+		}
+		retentions[formatRetentionList(attrs.Specs)]++
+		methods[attrs.AggregationMethod.String()]++
+		for _, s := range attrs.Specs {
+			sum.Points += s.points()
+		}
+		if dataErr != nil {
+			fmt.Fprintf(os.Stderr, "Skipping data of %s: %v\n", f, dataErr)
+		} else if !hasData {
+			sum.EmptyFiles++
+		}
+	}
+
+	if len(sizes) > 0 {
+		sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+		sum.MinBytes = sizes[0]
+		sum.MaxBytes = sizes[len(sizes)-1]
+		mid := len(sizes) / 2
+		sum.MedianBytes = sizes[mid]
+		if len(sizes)%2 == 0 {
+			sum.MedianBytes = (sizes[mid-1] + sizes[mid]) / 2
+		}
+	}
+	sum.Retentions = sortedBuckets(retentions)
+	sum.AggregationMethods = sortedBuckets(methods)
+	return sum, nil
+}
+
+// summarizeFile reads the attributes of the whisper file at path, see readFileAttrs, and
+// whether any of its archives holds at least one point within its retention window, opening
+// the file once and read-only. dataErr is set when only the data can't be read, which includes
+// go-whisper's compressed format.
+func summarizeFile(path string, now int) (attrs fileAttrs, hasData bool, dataErr, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return fileAttrs{}, false, nil, err
+	}
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to close file %s %v\n", path, err)
+		}
+	}()
+
+	header, err := readWhisperHeader(f)
+	if errors.Is(err, errCompressedWhisper) {
+		attrs, err = readCompressedFileAttrs(path)
+		return attrs, false, errCompressedWhisper, err
+	}
+	if err != nil {
+		return fileAttrs{}, false, nil, err
+	}
+	for _, a := range header.Archives {
+		points, err := readArchivePoints(f, a, now)
+		if err != nil {
+			return headerAttrs(header), false, err, nil
+		}
+		if len(points) > 0 {
+			return headerAttrs(header), true, nil, nil
+		}
+	}
+	return headerAttrs(header), false, nil, nil
+}
+
+// humanBytes renders a byte count with a binary unit, e.g. 1536 -> "1.5KiB".
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// printTreeSummary prints sum either as a human readable report or, with format "json", as a
+// single JSON object.
+func printTreeSummary(sum treeSummary, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(sum)
+	}
+
+	readable := sum.Files - sum.Unreadable
+	fmt.Printf("Directory: %s\n", sum.Root)
+	fmt.Printf("Files: %d (%d readable, %.1f%%)\n", sum.Files, readable, percent(readable, sum.Files))
+	fmt.Printf("Size on disk: %s (min %s, median %s, max %s)\n",
+		humanBytes(sum.TotalBytes), humanBytes(sum.MinBytes), humanBytes(sum.MedianBytes), humanBytes(sum.MaxBytes))
+	fmt.Printf("Points capacity: %d\n", sum.Points)
+	fmt.Printf("Files without data: %d\n", sum.EmptyFiles)
+	printBuckets := func(title string, buckets []histogramBucket) {
+		fmt.Printf("Distinct %s: %d\n", title, len(buckets))
+		for _, b := range buckets {
+			fmt.Printf("  %6d  %s\n", b.Count, strings.ReplaceAll(b.Key, "\t", " "))
+		}
+	}
+	printBuckets("retentions", sum.Retentions)
+	printBuckets("aggregation methods", sum.AggregationMethods)
+	return nil
+}
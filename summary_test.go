@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	whisper "github.com/go-graphite/go-whisper"
+)
+
+func TestSummarizeFile(t *testing.T) {
+	dir := t.TempDir()
+	now := int(time.Now().Unix())
+	empty := filepath.Join(dir, "empty.wsp")
+	writeTestWhisper(t, empty, "1m:1d,1h:30d", whisper.Max, 0.2)
+	full := filepath.Join(dir, "full.wsp")
+	writeTestWhisper(t, full, "1m:1d", whisper.Sum, 0)
+	w, err := whisper.Open(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Update(1, now-60); err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Close()
+	broken := filepath.Join(dir, "broken.wsp")
+	if err := os.WriteFile(broken, []byte("not whisper"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	attrs, hasData, dataErr, err := summarizeFile(empty, now)
+	if err != nil || dataErr != nil || hasData {
+		t.Errorf("empty: hasData %t, %v, %v, want no data and no errors", hasData, dataErr, err)
+	}
+	if formatRetentionList(attrs.Specs) != "1m:1d,1h:30d" || attrs.AggregationMethod != whisper.Max || attrs.XFilesFactor != 0.2 {
+		t.Errorf("empty: got %+v", attrs)
+	}
+	if _, hasData, dataErr, err = summarizeFile(full, now); err != nil || dataErr != nil || !hasData {
+		t.Errorf("full: hasData %t, %v, %v, want data and no errors", hasData, dataErr, err)
+	}
+	if _, _, _, err = summarizeFile(broken, now); err == nil {
+		t.Error("broken: want error")
+	}
+}
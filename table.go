@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+)
+
+// tableStyles are the values accepted by --table-style.
+var tableStyles = []string{"plain", "ascii", "unicode"}
+
+// tableWriter renders rows of cells as an aligned table. Nothing is guaranteed to be written
+// before flush.
+type tableWriter interface {
+	header(cells ...string)
+	row(cells ...string)
+	flush() error
+}
+
+// newTableWriter returns a tableWriter for style, one of tableStyles: plain aligns columns with
+// spaces like the rest of the tool's output, ascii and unicode draw borders around every cell.
+func newTableWriter(w io.Writer, style string) tableWriter {
+	switch style {
+	case "ascii":
+		return &borderedTable{w: w, box: asciiBox}
+	case "unicode":
+		return &borderedTable{w: w, box: unicodeBox}
+	}
+	return plainTable{tabwriter.NewWriter(w, 2, 4, 2, ' ', 0)}
+}
+
+// plainTable is the borderless tabwriter table.
+type plainTable struct {
+	wr *tabwriter.Writer
+}
+
+func (t plainTable) header(cells ...string) { t.row(cells...) }
+
+func (t plainTable) row(cells ...string) {
+	_, _ = fmt.Fprintln(t.wr, strings.Join(cells, "\t"))
+}
+
+func (t plainTable) flush() error { return t.wr.Flush() }
+
+// boxChars are the characters a borderedTable is drawn with. The corner and junction strings
+// are indexed top, middle (below the header), bottom.
+type boxChars struct {
+	horizontal, vertical string
+	left, cross, right   [3]string
+}
+
+var (
+	asciiBox = boxChars{
+		horizontal: "-", vertical: "|",
+		left: [3]string{"+", "+", "+"}, cross: [3]string{"+", "+", "+"}, right: [3]string{"+", "+", "+"},
+	}
+	unicodeBox = boxChars{
+		horizontal: "─", vertical: "│",
+		left: [3]string{"┌", "├", "└"}, cross: [3]string{"┬", "┼", "┴"}, right: [3]string{"┐", "┤", "┘"},
+	}
+)
+
+// borderedTable buffers all rows to size the columns and draws them with borders on flush.
+type borderedTable struct {
+	w       io.Writer
+	box     boxChars
+	head    []string
+	rows    [][]string
+	columns int
+}
+
+func (t *borderedTable) header(cells ...string) {
+	t.head = cells
+	t.columns = max(t.columns, len(cells))
+}
+
+func (t *borderedTable) row(cells ...string) {
+	t.rows = append(t.rows, cells)
+	t.columns = max(t.columns, len(cells))
+}
+
+func (t *borderedTable) flush() error {
+	widths := make([]int, t.columns)
+	for _, cells := range append([][]string{t.head}, t.rows...) {
+		for i, c := range cells {
+			widths[i] = max(widths[i], utf8.RuneCountInString(c))
+		}
+	}
+
+	var b strings.Builder
+	line := func(pos int) {
+		b.WriteString(t.box.left[pos])
+		for i, w := range widths {
+			if i > 0 {
+				b.WriteString(t.box.cross[pos])
+			}
+			b.WriteString(strings.Repeat(t.box.horizontal, w+2))
+		}
+		b.WriteString(t.box.right[pos] + "\n")
+	}
+	cells := func(cells []string) {
+		b.WriteString(t.box.vertical)
+		for i, w := range widths {
+			c := ""
+			if i < len(cells) {
+				c = cells[i]
+			}
+			b.WriteString(" " + c + strings.Repeat(" ", w-utf8.RuneCountInString(c)) + " " + t.box.vertical)
+		}
+		b.WriteString("\n")
+	}
+
+	line(0)
+	if t.head != nil {
+		cells(t.head)
+		line(1)
+	}
+	for _, r := range t.rows {
+		cells(r)
+	}
+	line(2)
+	_, err := io.WriteString(t.w, b.String())
+	return err
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+
+	whisper "github.com/go-graphite/go-whisper"
+)
+
+// forEachTarEntry calls fn for every regular file in the tar archive at tarPath, which may be
+// gzip-compressed. fn reads the entry's content from r; it is only valid during the call.
+// Returning an error from fn stops the iteration with that error.
+func forEachTarEntry(tarPath string, fn func(hdr *tar.Header, r io.Reader) error) error {
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to close file %s %v\n", tarPath, err)
+		}
+	}()
+
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	// detect gzip by its magic bytes rather than the file name, backups are named inconsistently
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = gz.Close() }()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err = fn(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// tarEntryName normalizes the name of a tar entry for comparison, dropping a leading "./" or "/".
+func tarEntryName(name string) string {
+	name = path.Clean("/" + name)
+	return name[1:]
+}
+
+// openTarWhisper loads the entry name of the tar archive at tarPath into memory and opens it
+// as a whisper file. It also returns the entry's header for its size and modification time.
+func openTarWhisper(tarPath, name string) (*whisper.Whisper, *tar.Header, error) {
+	want := tarEntryName(name)
+	var found *tar.Header
+	var data []byte
+	err := forEachTarEntry(tarPath, func(hdr *tar.Header, r io.Reader) error {
+		if tarEntryName(hdr.Name) != want {
+			return nil
+		}
+		var err error
+		data, err = io.ReadAll(r)
+		found = hdr
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if found == nil {
+		return nil, nil, fmt.Errorf("no entry %s in %s", want, tarPath)
+	}
+	w, err := whisper.OpenWithOptions(want, &whisper.Options{InMemory: true, InMemoryContent: data})
+	return w, found, err
+}
+
+// checkTarArchive checks every whisper file in the tar archive at tarPath against schemas,
+// without extracting anything: entries are read into memory one at a time. Entry names are
+// treated as paths below the whisper root, so metric names and the --extension and
+// --metrics-file filters of opts.Walk work like for a directory tree. Results are sorted by
+// entry name.
+func checkTarArchive(tarPath string, schemas []Schema, opts checkOptions) ([]checkResult, error) {
+	var results []checkResult
+	err := forEachTarEntry(tarPath, func(hdr *tar.Header, r io.Reader) error {
+		name := tarEntryName(hdr.Name)
+		if !opts.Walk.matchesExtension(name) || !opts.Walk.keepMetric(".", name) {
+			return nil
+		}
+		res := checkResult{Source: tarPath, Metric: opts.Walk.metricName(".", name), Path: name}
+		res = checkMetric(res, schemas, opts, func() (fileAttrs, error) {
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return fileAttrs{}, err
+			}
+			w, err := whisper.OpenWithOptions(name, &whisper.Options{InMemory: true, InMemoryContent: data})
+			if err != nil {
+				return fileAttrs{}, err
+			}
+			return whisperAttrs(w), w.Close()
+		})
+		if opts.Explain {
+			res.Trace = explainMatch(schemas, res.Metric)
+		}
+		if opts.Verbose {
+			res.addPointCounts()
+		}
+		results = append(results, res)
+		opts.Timing.addFiles(1)
+		opts.Tally.add(res)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 && !opts.AllowEmpty {
+		return nil, fmt.Errorf("no .wsp files found in %s", tarPath)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Path < results[j].Path })
+	return results, nil
+}
+
+// checkTarRetentions checks the tar archive at tarPath like checkRetentions checks a tree and
+// prints one row per whisper file to stdout. It returns true if any mismatch or error was found.
+func checkTarRetentions(tarPath string, schemas []Schema, opts checkOptions) (bool, error) {
+	results, err := checkTarArchive(tarPath, schemas, opts)
+	if err != nil {
+		return false, err
+	}
+	mismatchFound := false
+	for _, r := range results {
+		if r.failed(opts.FailOn) {
+			mismatchFound = true
+		}
+	}
+	if opts.Score {
+		return mismatchFound, nil
+	}
+	return mismatchFound, writeCheckResults(os.Stdout, results, false, opts)
+}
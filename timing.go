@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Phases of a run measured by --timing.
+const (
+	phaseWalk    = iota // finding files
+	phaseOpen           // opening files and reading their headers
+	phaseCompare        // matching schemas and comparing retentions
+	numPhases
+)
+
+var phaseNames = [numPhases]string{"walk", "open", "compare"}
+
+// runTiming accumulates where a run spent its time for --timing. Workers add to it
+// concurrently, so the phase totals are summed over all workers and may exceed the wall time.
+// All methods are no-ops on a nil *runTiming, so callers don't need to check whether timing
+// is enabled.
+type runTiming struct {
+	start  time.Time
+	files  atomic.Int64
+	phases [numPhases]atomic.Int64 // nanoseconds
+}
+
+func newRunTiming() *runTiming {
+	return &runTiming{start: time.Now()}
+}
+
+// since adds the time passed since start to phase.
+func (t *runTiming) since(phase int, start time.Time) {
+	if t != nil {
+		t.phases[phase].Add(int64(time.Since(start)))
+	}
+}
+
+// addFiles adds n to the number of files processed.
+func (t *runTiming) addFiles(n int) {
+	if t != nil {
+		t.files.Add(int64(n))
+	}
+}
+
+// print writes the wall time, the files processed per second and the time spent per phase.
+func (t *runTiming) print(w io.Writer) {
+	if t == nil {
+		return
+	}
+	wall := time.Since(t.start)
+	files := t.files.Load()
+	_, _ = fmt.Fprintf(w, "timing: %s wall, %d files, %.0f files/s\n", wall.Round(time.Microsecond), files, float64(files)/wall.Seconds())
+	for i, name := range phaseNames {
+		d := time.Duration(t.phases[i].Load())
+		_, _ = fmt.Fprintf(w, "  %-8s %s\n", name, d.Round(time.Microsecond))
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestRunTimingPrintSubMillisecond(t *testing.T) {
+	timing := &runTiming{start: time.Now().Add(-300 * time.Microsecond)}
+	timing.addFiles(3)
+	timing.since(phaseOpen, time.Now().Add(-100*time.Microsecond))
+	var b bytes.Buffer
+	timing.print(&b)
+	want := regexp.MustCompile(`^timing: \d+(\.\d+)?(µs|ms) wall, 3 files, \d+ files/s\n`)
+	if !want.Match(b.Bytes()) {
+		t.Errorf("got %q, want a sub-second wall time", b.String())
+	}
+
+	var none *runTiming
+	none.print(&b) // no-op
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	whisper "github.com/go-graphite/go-whisper"
+)
+
+// touchResult describes the file created by touchMetric.
+type touchResult struct {
+	Path              string
+	Schema            *Schema
+	AggregationMethod whisper.AggregationMethod
+	XFilesFactor      float32
+}
+
+// touchMetric creates an empty .wsp file for metric under root with the retentions of the
+// first matching schema and the aggregation carbon would pick, like carbon does on the first
+// datapoint of a new metric. It fails if no schema matches or the file already exists. With
+// dryRun the checks are done but nothing is written.
+func touchMetric(root, metric string, schemas []Schema, rules []AggregationRule, defaultXFF float32, dryRun bool) (touchResult, error) {
+	res := touchResult{Path: pathFromMetric(root, metric)}
+	res.Schema = matchSchema(schemas, metric)
+	if res.Schema == nil {
+		return res, fmt.Errorf("no schema matches %s", metric)
+	}
+	res.AggregationMethod, res.XFilesFactor = resolveAggregation(rules, metric, defaultXFF)
+	if err := checkArchiveList(res.Schema.Retentions); err != nil {
+		return res, fmt.Errorf("schema [%s]: %v", res.Schema.Name, err)
+	}
+
+	if _, err := os.Stat(res.Path); err == nil {
+		return res, fmt.Errorf("%s already exists", res.Path)
+	}
+	if dryRun {
+		return res, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(res.Path), 0o755); err != nil {
+		return res, err
+	}
+
+	retentions := make(whisper.Retentions, 0, len(res.Schema.Retentions))
+	for _, spec := range res.Schema.Retentions {
+		r := whisper.NewRetention(spec.SecondsPerPoint, spec.points())
+		retentions = append(retentions, &r)
+	}
+	w, err := whisper.Create(res.Path, retentions, res.AggregationMethod, res.XFilesFactor)
+	if err != nil {
+		return res, err
+	}
+	return res, w.Close()
+}
+
+// checkArchiveList applies the rules whisper.Create enforces on a file's archives, so a dry run
+// fails the same way the real one would: resolutions must increase and divide each other,
+// every archive must cover more time than the previous one and hold enough points to fill
+// one point of the next.
+func checkArchiveList(specs []ArchiveSpec) error {
+	if len(specs) == 0 {
+		return fmt.Errorf("no retentions")
+	}
+	for i, s := range specs {
+		if s.SecondsPerPoint <= 0 || s.points() == 0 {
+			return fmt.Errorf("archive %d (%s) must have a positive resolution and at least one point", i, s.toHuman())
+		}
+	}
+	for i := 1; i < len(specs); i++ {
+		prev, cur := specs[i-1], specs[i]
+		prevPoints := prev.points()
+		switch {
+		case cur.SecondsPerPoint <= prev.SecondsPerPoint:
+			return fmt.Errorf("archive %d (%s) must be coarser than archive %d (%s)", i, cur.toHuman(), i-1, prev.toHuman())
+		case cur.SecondsPerPoint%prev.SecondsPerPoint != 0:
+			return fmt.Errorf("resolution %s of archive %d is not a multiple of %s of archive %d", toHuman(cur.SecondsPerPoint), i, toHuman(prev.SecondsPerPoint), i-1)
+		case cur.normalized().RetentionSecs <= prev.normalized().RetentionSecs:
+			return fmt.Errorf("archive %d (%s) must cover more time than archive %d (%s)", i, cur.toHuman(), i-1, prev.toHuman())
+		case prevPoints < cur.SecondsPerPoint/prev.SecondsPerPoint:
+			return fmt.Errorf("archive %d (%s) has fewer than the %d points needed to fill one point of archive %d", i-1, prev.toHuman(), cur.SecondsPerPoint/prev.SecondsPerPoint, i)
+		}
+	}
+	return nil
+}
+
+// printTouchPlan prints the archives touchMetric would create for res, with the number of points
+// whisper allocates for each (retention / resolution, rounded down) and the resulting file size.
+// Archives whose retention isn't a multiple of their resolution are flagged, as they keep
+// slightly less history than written in the schema.
+func printTouchPlan(res touchResult) error {
+	fmt.Printf("would create %s (schema [%s], %s, xFilesFactor %g)\n\n", res.Path, res.Schema.Name, res.AggregationMethod, res.XFilesFactor)
+	wr := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(wr, "archive\tseconds/point\t#points\tretention\tnote")
+	size := whisper.MetadataSize + len(res.Schema.Retentions)*whisper.ArchiveInfoSize
+	for i, spec := range res.Schema.Retentions {
+		points := spec.points()
+		size += points * whisper.PointSize
+		note := ""
+		if spec.RetentionSecs%spec.SecondsPerPoint != 0 {
+			note = fmt.Sprintf("%s is not a multiple of %s, keeps only %s", toHuman(spec.RetentionSecs), toHuman(spec.SecondsPerPoint), toHuman(points*spec.SecondsPerPoint))
+		}
+		_, _ = fmt.Fprintf(wr, "%d\t%d\t%d\t%s\t%s\n", i, spec.SecondsPerPoint, points, toHuman(points*spec.SecondsPerPoint), note)
+	}
+	if err := wr.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("\nEstimated file size: %d bytes (%s)\n", size, humanBytes(int64(size)))
+	return nil
+}
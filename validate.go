@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	whisper "github.com/go-graphite/go-whisper"
+)
+
+// validationIssue is a single finding of --validate, tied to a section of a config file.
+type validationIssue struct {
+	Level   string // "ERROR" or "WARN"
+	File    string
+	Section string
+	LineNo  int
+	Detail  string
+}
+
+// parseAllowedAggregations parses a comma separated list of aggregation methods such as
+// "average,sum".
+func parseAllowedAggregations(s string) ([]whisper.AggregationMethod, error) {
+	var out []whisper.AggregationMethod
+	for p := range strings.SplitSeq(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		m, err := normalizeAggregationMethod(p)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no aggregation methods parsed from %q", s)
+	}
+	return out, nil
+}
+
+// checkAllowedAggregations flags every rule whose aggregationMethod is not in allowed.
+// Rules that don't set a method are not flagged.
+func checkAllowedAggregations(path string, rules []AggregationRule, allowed []whisper.AggregationMethod) []validationIssue {
+	names := make([]string, len(allowed))
+	for i, m := range allowed {
+		names[i] = m.String()
+	}
+	var issues []validationIssue
+	for _, r := range rules {
+		if r.AggregationMethod == 0 {
+			continue
+		}
+		ok := false
+		for _, m := range allowed {
+			if r.AggregationMethod == m {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			issues = append(issues, validationIssue{
+				Level:   "ERROR",
+				File:    path,
+				Section: r.Name,
+				LineNo:  r.LineNo,
+				Detail:  fmt.Sprintf("aggregationMethod %s is not allowed (allowed: %s)", r.AggregationMethod, strings.Join(names, ", ")),
+			})
+		}
+	}
+	return issues
+}
+
+// checkRetentionPolicy flags every retention spec finer than minResolution or kept longer
+// than maxRetention (both in seconds, zero disables the check).
+func checkRetentionPolicy(path string, schemas []Schema, minResolution, maxRetention int) []validationIssue {
+	var issues []validationIssue
+	for _, s := range schemas {
+		for _, spec := range s.Retentions {
+			if minResolution > 0 && spec.SecondsPerPoint < minResolution {
+				issues = append(issues, validationIssue{
+					Level:   "ERROR",
+					File:    path,
+					Section: s.Name,
+					LineNo:  s.LineNo,
+					Detail:  fmt.Sprintf("%s: resolution %s is finer than the minimum %s", spec.toHuman(), toHuman(spec.SecondsPerPoint), toHuman(minResolution)),
+				})
+			}
+			if maxRetention > 0 && spec.RetentionSecs > maxRetention {
+				issues = append(issues, validationIssue{
+					Level:   "ERROR",
+					File:    path,
+					Section: s.Name,
+					LineNo:  s.LineNo,
+					Detail:  fmt.Sprintf("%s: retention %s exceeds the maximum %s", spec.toHuman(), toHuman(spec.RetentionSecs), toHuman(maxRetention)),
+				})
+			}
+		}
+	}
+	return issues
+}
+
+// checkCarbonInterval flags every schema whose finest resolution is finer than carbon's flush
+// interval: carbon writes at most one point per interval, so only every n-th slot of such an
+// archive is ever filled. The detail gives the resulting population, which is also the highest
+// xFilesFactor that still lets those points aggregate into the next archive.
+func checkCarbonInterval(path string, schemas []Schema, interval int) []validationIssue {
+	var issues []validationIssue
+	for _, s := range schemas {
+		finest := finestResolution(s.Retentions)
+		if finest == 0 || finest >= interval {
+			continue
+		}
+		population := float64(finest) / float64(interval)
+		issues = append(issues, validationIssue{
+			Level:   "WARN",
+			File:    path,
+			Section: s.Name,
+			LineNo:  s.LineNo,
+			Detail: fmt.Sprintf("resolution %s is finer than the carbon interval %s: at most %.0f%% of its points get written, aggregating needs xFilesFactor <= %.2g",
+				toHuman(finest), toHuman(interval), population*100, population),
+		})
+	}
+	return issues
+}
+
+// suspiciousRetentionFactor is how many points an archive must keep for --warn-suspicious to
+// leave it alone.
+const suspiciousRetentionFactor = 10
+
+// checkSuspiciousRetentions flags every retention spec keeping fewer than
+// suspiciousRetentionFactor points: such a short archive is rarely intended and usually a unit
+// typo, e.g. 1h:2m where 1h:2w was meant. The detail gives the parsed seconds of both sides so
+// the intent can be confirmed.
+func checkSuspiciousRetentions(path string, schemas []Schema) []validationIssue {
+	var issues []validationIssue
+	for _, s := range schemas {
+		for _, spec := range s.Retentions {
+			if spec.RetentionSecs >= suspiciousRetentionFactor*spec.SecondsPerPoint {
+				continue
+			}
+			issues = append(issues, validationIssue{
+				Level:   "WARN",
+				File:    path,
+				Section: s.Name,
+				LineNo:  s.LineNo,
+				Detail: fmt.Sprintf("%s: retention %ds is less than %d times the resolution %ds, a unit typo?",
+					spec.toHuman(), spec.RetentionSecs, suspiciousRetentionFactor, spec.SecondsPerPoint),
+			})
+		}
+	}
+	return issues
+}
+
+// unanchoredPatternIssue returns a WARN for a regex pattern anchored at neither end: carbon
+// searches patterns anywhere in the metric name, so "servers" also matches
+// "stats.old_servers.count". Suffix patterns such as \.count$ and catch-alls such as .* are
+// intentional and aren't flagged. The detail suggests anchoring the start, with a trailing \.
+// for a plain word.
+func unanchoredPatternIssue(path, section string, lineNo int, matchType, raw string, re *regexp.Regexp) (validationIssue, bool) {
+	if matchType != matchRegex || raw == "" || strings.HasPrefix(raw, "^") || (strings.HasSuffix(raw, "$") && !strings.HasSuffix(raw, `\$`)) || matchesAllProbes(matchType, raw, re) {
+		return validationIssue{}, false
+	}
+	suggestion := "^" + raw
+	if regexp.QuoteMeta(raw) == raw {
+		suggestion += `\.`
+	}
+	return validationIssue{
+		Level:   "WARN",
+		File:    path,
+		Section: section,
+		LineNo:  lineNo,
+		Detail:  fmt.Sprintf("pattern %s isn't anchored, so it matches anywhere in a metric name; did you mean %s?", raw, suggestion),
+	}, true
+}
+
+// checkUnanchoredPatterns flags the schemas with an unanchored pattern, see
+// unanchoredPatternIssue.
+func checkUnanchoredPatterns(path string, schemas []Schema) []validationIssue {
+	var issues []validationIssue
+	for _, s := range schemas {
+		if issue, ok := unanchoredPatternIssue(path, s.Name, s.LineNo, s.MatchType, s.PatternRaw, s.Pattern); ok {
+			issues = append(issues, issue)
+		}
+	}
+	return issues
+}
+
+// checkRulesAfterCatchAll returns a WARN for every aggregation rule after the first catch-all:
+// carbon uses the first rule matching a metric, so those rules never apply.
+func checkRulesAfterCatchAll(path string, rules []AggregationRule) []validationIssue {
+	i := catchAllIndex(rules)
+	if i < 0 {
+		return nil
+	}
+	var issues []validationIssue
+	for _, r := range rules[i+1:] {
+		issues = append(issues, validationIssue{
+			Level:   "WARN",
+			File:    path,
+			Section: r.Name,
+			LineNo:  r.LineNo,
+			Detail:  fmt.Sprintf("never applies: the catch-all [%s] on line %d comes first and matches every metric", rules[i].Name, rules[i].LineNo),
+		})
+	}
+	return issues
+}
+
+// checkUnanchoredAggregationPatterns is checkUnanchoredPatterns for aggregation rules.
+func checkUnanchoredAggregationPatterns(path string, rules []AggregationRule) []validationIssue {
+	var issues []validationIssue
+	for _, r := range rules {
+		if issue, ok := unanchoredPatternIssue(path, r.Name, r.LineNo, r.MatchType, r.PatternRaw, r.Pattern); ok {
+			issues = append(issues, issue)
+		}
+	}
+	return issues
+}
+
+// checkRetentionOrder flags every schema whose retentions don't go strictly from finest to
+// coarsest resolution, which whisper requires of a file's archives.
+func checkRetentionOrder(path string, schemas []Schema) []validationIssue {
+	var issues []validationIssue
+	for _, s := range schemas {
+		for i := 1; i < len(s.Retentions); i++ {
+			prev, cur := s.Retentions[i-1], s.Retentions[i]
+			if cur.SecondsPerPoint <= prev.SecondsPerPoint {
+				issues = append(issues, validationIssue{
+					Level:   "ERROR",
+					File:    path,
+					Section: s.Name,
+					LineNo:  s.LineNo,
+					Detail:  fmt.Sprintf("%s follows %s: retentions must go from finest to coarsest resolution", cur.toHuman(), prev.toHuman()),
+				})
+			}
+		}
+	}
+	return issues
+}
+
+// printValidationIssues renders issues as a table and reports whether any of them is an error.
+func printValidationIssues(issues []validationIssue) (bool, error) {
+	if len(issues) == 0 {
+		fmt.Println("no issues found")
+		return false, nil
+	}
+	wr := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(wr, "level\tfile\tsection\tline\tdetail")
+	hasError := false
+	for _, i := range issues {
+		if i.Level == "ERROR" {
+			hasError = true
+		}
+		section, line := "-", "-"
+		if i.Section != "" {
+			section = "[" + i.Section + "]"
+			line = fmt.Sprint(i.LineNo)
+		}
+		_, _ = fmt.Fprintf(wr, "%s\t%s\t%s\t%s\t%s\n", i.Level, i.File, section, line, i.Detail)
+	}
+	return hasError, wr.Flush()
+}
+
+// validateOptions selects the config files and policies checked by validateConfigs.
+type validateOptions struct {
+	SchemasPath         string
+	AggregationPath     string
+	AllowedAggregations []whisper.AggregationMethod
+	MinResolution       int // seconds, 0 disables the check
+	MaxRetention        int // seconds, 0 disables the check
+	CarbonInterval      int // seconds, 0 disables the check
+	WarnSuspicious      bool
+	StrictParse         bool
+}
+
+// validateConfigs parses the configured files and applies the selected policy checks. A file
+// that can't be read or parsed is returned as an error, it is broken rather than a finding.
+func validateConfigs(opts validateOptions) ([]validationIssue, error) {
+	var issues []validationIssue
+	if opts.SchemasPath != "" {
+		schemas, err := parseStorageSchemas(opts.SchemasPath, opts.StrictParse)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse schemas %s: %v", opts.SchemasPath, err)
+		}
+		issues = append(issues, checkRetentionOrder(opts.SchemasPath, schemas)...)
+		issues = append(issues, checkUnanchoredPatterns(opts.SchemasPath, schemas)...)
+		issues = append(issues, checkRetentionPolicy(opts.SchemasPath, schemas, opts.MinResolution, opts.MaxRetention)...)
+		if opts.CarbonInterval > 0 {
+			issues = append(issues, checkCarbonInterval(opts.SchemasPath, schemas, opts.CarbonInterval)...)
+		}
+		if opts.WarnSuspicious {
+			issues = append(issues, checkSuspiciousRetentions(opts.SchemasPath, schemas)...)
+		}
+	}
+	if opts.AggregationPath != "" {
+		rules, err := parseStorageAggregation(opts.AggregationPath, opts.StrictParse)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse aggregation %s: %v", opts.AggregationPath, err)
+		}
+		if len(opts.AllowedAggregations) > 0 {
+			issues = append(issues, checkAllowedAggregations(opts.AggregationPath, rules, opts.AllowedAggregations)...)
+		}
+		issues = append(issues, checkUnanchoredAggregationPatterns(opts.AggregationPath, rules)...)
+		if !hasCatchAll(rules) {
+			issues = append(issues, validationIssue{Level: "WARN", File: opts.AggregationPath, Detail: missingCatchAllWarning})
+		}
+		issues = append(issues, checkRulesAfterCatchAll(opts.AggregationPath, rules)...)
+	}
+	return issues, nil
+}
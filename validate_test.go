@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckRulesAfterCatchAll(t *testing.T) {
+	conf := "[min]\npattern = \\.min$\naggregationMethod = min\n\n" +
+		"[default]\npattern = .*\n\n" +
+		"[sum]\npattern = \\.count$\naggregationMethod = sum\n"
+	rules, err := parseStorageAggregation(writeTestFile(t, "storage-aggregation.conf", conf), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issues := checkRulesAfterCatchAll("storage-aggregation.conf", rules)
+	if len(issues) != 1 {
+		t.Fatalf("got %+v, want one issue", issues)
+	}
+	if got := issues[0]; got.Level != "WARN" || got.Section != "sum" || got.LineNo != 8 || !strings.Contains(got.Detail, "catch-all [default] on line 5") {
+		t.Errorf("got %+v, want a WARN for [sum] on line 8 naming [default] on line 5", got)
+	}
+
+	if issues := checkRulesAfterCatchAll("storage-aggregation.conf", rules[:2]); len(issues) != 0 {
+		t.Errorf("catch-all last: got %+v, want none", issues)
+	}
+}
+
+func TestCheckSuspiciousRetentions(t *testing.T) {
+	tests := []struct {
+		retentions string
+		want       bool
+	}{
+		{"1h:2m", true},   // 120s, a typo for 2w
+		{"1h:2w", false},  // 336 points
+		{"1m:9m", true},   // 9 points, just below the factor
+		{"1m:10m", false}, // exactly suspiciousRetentionFactor points
+		{"10s:6h", false}, // a usual finest archive
+		{"1m:1d,1d:5d", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.retentions, func(t *testing.T) {
+			specs, err := parseRetentionList(tt.retentions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			issues := checkSuspiciousRetentions("storage-schemas.conf", []Schema{{Name: "s", LineNo: 3, Retentions: specs}})
+			if got := len(issues) > 0; got != tt.want {
+				t.Fatalf("flagged %t, want %t: %+v", got, tt.want, issues)
+			}
+			if tt.want && (len(issues) != 1 || issues[0].Level != "WARN" || issues[0].Section != "s" || issues[0].LineNo != 3) {
+				t.Errorf("got %+v, want one WARN for [s] on line 3", issues)
+			}
+		})
+	}
+
+	specs, _ := parseRetentionList("1h:2m")
+	issues := checkSuspiciousRetentions("storage-schemas.conf", []Schema{{Name: "s", Retentions: specs}})
+	if want := "retention 120s is less than 10 times the resolution 3600s"; len(issues) != 1 || !strings.Contains(issues[0].Detail, want) {
+		t.Errorf("got %+v, want detail with %q", issues, want)
+	}
+}
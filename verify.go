@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	whisper "github.com/go-graphite/go-whisper"
+)
+
+// Categories assigned to files by classifyWhisperFile.
+const (
+	verifyOK               = "ok"
+	verifyZeroLength       = "zero-length"
+	verifyTruncated        = "truncated"
+	verifyWrongFormat      = "wrong-format"
+	verifyPermissionDenied = "permission-denied"
+	verifyUnreadable       = "unreadable"
+)
+
+// verifyCategories lists the categories in the order they are summarized.
+var verifyCategories = []string{verifyOK, verifyZeroLength, verifyTruncated, verifyWrongFormat, verifyPermissionDenied, verifyUnreadable}
+
+// classifyWhisperFile checks that path looks like an intact whisper file and returns one of the
+// verify* categories with a detail message for anything but verifyOK.
+func classifyWhisperFile(path string) (string, string) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsPermission(err) {
+			return verifyPermissionDenied, err.Error()
+		}
+		return verifyUnreadable, err.Error()
+	}
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to close file %s %v\n", path, err)
+		}
+	}()
+	st, err := f.Stat()
+	if err != nil {
+		return verifyUnreadable, err.Error()
+	}
+	size := st.Size()
+	if size == 0 {
+		return verifyZeroLength, "file is empty"
+	}
+
+	head := make([]byte, len(compressedMagic))
+	n, err := f.ReadAt(head, 0)
+	if n < whisper.MetadataSize {
+		if err != nil && n == 0 {
+			return verifyUnreadable, err.Error()
+		}
+		return verifyTruncated, fmt.Sprintf("%d bytes is shorter than the %d byte header", size, whisper.MetadataSize)
+	}
+	if !bytes.Equal(head[:n], compressedMagic[:n]) || n < len(compressedMagic) {
+		if category, detail := checkClassicLayout(f, size); category != verifyOK {
+			return category, detail
+		}
+	}
+
+	// let go-whisper have the final word, read-only so verifying never needs write access
+	flags := os.O_RDONLY
+	w, err := whisper.OpenWithOptions(path, &whisper.Options{OpenFileFlag: &flags})
+	if err != nil {
+		return verifyWrongFormat, err.Error()
+	}
+	_ = w.Close()
+	return verifyOK, ""
+}
+
+// checkClassicLayout validates the header of an uncompressed whisper file of the given size:
+// a known aggregation method, a sane archive count and contiguous archives ending exactly at
+// the end of the file.
+func checkClassicLayout(f *os.File, size int64) (string, string) {
+	meta := make([]byte, whisper.MetadataSize)
+	if _, err := f.ReadAt(meta, 0); err != nil {
+		return verifyUnreadable, err.Error()
+	}
+	method := whisper.AggregationMethod(binary.BigEndian.Uint32(meta[0:4]))
+	if whisper.ParseAggregationMethod(method.String()) == whisper.Unknown {
+		return verifyWrongFormat, fmt.Sprintf("unknown aggregation method %d in header", method)
+	}
+	count := int(binary.BigEndian.Uint32(meta[12:16]))
+	if count == 0 || count > maxArchives {
+		return verifyWrongFormat, fmt.Sprintf("implausible archive count %d in header", count)
+	}
+	headerSize := int64(whisper.MetadataSize + count*whisper.ArchiveInfoSize)
+	if size < headerSize {
+		return verifyTruncated, fmt.Sprintf("%d bytes is shorter than the %d byte header", size, headerSize)
+	}
+
+	h, err := readWhisperHeader(f)
+	if err != nil {
+		return verifyWrongFormat, err.Error()
+	}
+	expected := headerSize
+	for i, a := range h.Archives {
+		if a.SecondsPerPoint <= 0 || a.Points <= 0 {
+			return verifyWrongFormat, fmt.Sprintf("archive %d has %d seconds/point and %d points", i, a.SecondsPerPoint, a.Points)
+		}
+		if a.Offset != expected {
+			return verifyWrongFormat, fmt.Sprintf("archive %d starts at offset %d, expected %d", i, a.Offset, expected)
+		}
+		expected += int64(a.Points * whisper.PointSize)
+	}
+	if size < expected {
+		return verifyTruncated, fmt.Sprintf("%d bytes, header describes %d", size, expected)
+	}
+	if size > expected {
+		return verifyWrongFormat, fmt.Sprintf("%d trailing bytes after the last archive", size-expected)
+	}
+	return verifyOK, ""
+}
+
+// verifyProblem is a file classifyWhisperFile didn't find to be ok.
+type verifyProblem struct {
+	Category string
+	Path     string
+	Detail   string
+}
+
+// verifyTree classifies every .wsp file under root. It returns the files with problems and the
+// number of files per category.
+func verifyTree(root string, walk walkOptions) ([]verifyProblem, map[string]int, error) {
+	files, err := findWhisperFiles(root, walk)
+	if err != nil {
+		return nil, nil, err
+	}
+	var problems []verifyProblem
+	counts := map[string]int{}
+	for _, f := range files {
+		category, detail := classifyWhisperFile(f)
+		counts[category]++
+		if category != verifyOK {
+			problems = append(problems, verifyProblem{Category: category, Path: f, Detail: detail})
+		}
+	}
+	return problems, counts, nil
+}
+
+// printVerifyReport lists the problem files followed by a per-category summary.
+func printVerifyReport(problems []verifyProblem, counts map[string]int) error {
+	wr := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+	if len(problems) > 0 {
+		_, _ = fmt.Fprintln(wr, "category\tpath\tdetail")
+		for _, p := range problems {
+			_, _ = fmt.Fprintf(wr, "%s\t%s\t%s\n", p.Category, p.Path, p.Detail)
+		}
+		_, _ = fmt.Fprintln(wr)
+	}
+	_, _ = fmt.Fprintln(wr, "category\tfiles")
+	for _, c := range verifyCategories {
+		_, _ = fmt.Fprintf(wr, "%s\t%d\n", c, counts[c])
+	}
+	return wr.Flush()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build information, injected at build time with e.g.
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
+)
+
+// whisperModule is the module path of the whisper library whose version is reported.
+const whisperModule = "github.com/go-graphite/go-whisper"
+
+// printVersion writes the build information to w. Values not injected via -ldflags fall back
+// to what the Go toolchain embedded in the binary, when available.
+func printVersion(w io.Writer) {
+	rev, built, whisperVersion := commit, date, "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && rev == "unknown":
+				rev = s.Value
+			case s.Key == "vcs.time" && built == "unknown":
+				built = s.Value
+			}
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == whisperModule {
+				whisperVersion = dep.Version
+				if dep.Replace != nil {
+					whisperVersion += " => " + dep.Replace.Path + " " + dep.Replace.Version
+				}
+			}
+		}
+	}
+	_, _ = fmt.Fprintf(w, "version: %s\n", version)
+	_, _ = fmt.Fprintf(w, "commit: %s\n", rev)
+	_, _ = fmt.Fprintf(w, "built: %s\n", built)
+	_, _ = fmt.Fprintf(w, "go: %s\n", runtime.Version())
+	_, _ = fmt.Fprintf(w, "go-whisper: %s\n", whisperVersion)
+}
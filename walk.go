@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// walkOptions tweak which files findWhisperFiles returns.
+type walkOptions struct {
+	// DedupeFiles reports files that are hardlinks of the same inode only once.
+	DedupeFiles bool
+	// MaxDepth stops the walk from descending into directories more than this many levels
+	// below the root, 0 means unlimited.
+	MaxDepth int
+	// Extensions are the file name suffixes to include, matched case-insensitively.
+	// Empty means defaultExtension.
+	Extensions []string
+	// NoNormalize keeps metric names exactly as derived from paths, see metricName.
+	NoNormalize bool
+	// Metrics, when set, restricts the walk to files whose metric name it contains.
+	Metrics *metricList
+	// Prefix, when set, restricts the walk to the metric named Prefix and those below it.
+	Prefix string
+}
+
+// keepMetric reports whether file f under root passes the Prefix and Metrics filters.
+func (o walkOptions) keepMetric(root, f string) bool {
+	if o.Prefix == "" && o.Metrics == nil {
+		return true
+	}
+	metric := o.metricName(root, f)
+	if o.Prefix != "" && metric != o.Prefix && !strings.HasPrefix(metric, o.Prefix+".") {
+		return false
+	}
+	return o.Metrics == nil || o.Metrics.contains(metric)
+}
+
+// metricName derives the metric name of file f under root, normalized unless NoNormalize is set.
+func (o walkOptions) metricName(root, f string) string {
+	return metricFromPath(root, f, o.matchedExtension(f), !o.NoNormalize)
+}
+
+// defaultExtension is the suffix of whisper files carbon creates.
+const defaultExtension = ".wsp"
+
+// matchesExtension reports whether path ends with one of the configured extensions.
+func (o walkOptions) matchesExtension(path string) bool {
+	return o.matchedExtension(path) != ""
+}
+
+// matchedExtension returns the longest of the configured extensions path ends with, or "" if
+// it ends with none of them.
+func (o walkOptions) matchedExtension(path string) string {
+	exts := o.Extensions
+	if len(exts) == 0 {
+		exts = []string{defaultExtension}
+	}
+	lower := strings.ToLower(path)
+	matched := ""
+	for _, ext := range exts {
+		if len(ext) > len(matched) && strings.HasSuffix(lower, strings.ToLower(ext)) {
+			matched = ext
+		}
+	}
+	return matched
+}
+
+// stringList is a flag.Value collecting every occurrence of a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// dirDepth returns how many levels below root dir is.
+func dirDepth(root, dir string) int {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+// fileID identifies a physical file by device and inode.
+type fileID struct {
+	dev, ino uint64
+}
+
+// dedupeFiles drops every path that refers to the same physical file as an earlier path in
+// paths, e.g. hardlinks, keeping the order of the rest. Files that can't be stat'ed are kept.
+func dedupeFiles(paths []string) []string {
+	seen := map[fileID]bool{}
+	// where the stat result has no device and inode, only files of the same size can be the
+	// same file, which keeps os.SameFile calls down; all files of a schema have the same size
+	// though, so this is the fallback only
+	bySize := map[int64][]os.FileInfo{}
+	out := make([]string, 0, len(paths))
+	for _, p := range paths {
+		st, err := os.Stat(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping dedupe of %s: %v\n", p, err)
+			out = append(out, p)
+			continue
+		}
+		if sys, ok := st.Sys().(*syscall.Stat_t); ok {
+			id := fileID{dev: uint64(sys.Dev), ino: uint64(sys.Ino)}
+			if !seen[id] {
+				seen[id] = true
+				out = append(out, p)
+			}
+			continue
+		}
+		dup := false
+		for _, other := range bySize[st.Size()] {
+			if os.SameFile(st, other) {
+				dup = true
+				break
+			}
+		}
+		if dup {
+			continue
+		}
+		bySize[st.Size()] = append(bySize[st.Size()], st)
+		out = append(out, p)
+	}
+	return out
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	whisper "github.com/go-graphite/go-whisper"
+)
+
+func TestMetricNameExtensions(t *testing.T) {
+	tests := []struct {
+		name       string
+		extensions []string
+		file       string
+		want       string
+	}{
+		{"default", nil, "servers/web01/cpu.wsp", "servers.web01.cpu"},
+		{"dots before the extension are kept", nil, "a/c.d.wsp", "a.c.d"},
+		{"uppercase extension", nil, "a/X.WSP", "a.X"},
+		{"configured extension", []string{".wsp.bak"}, "a/c.wsp.bak", "a.c"},
+		{"longest configured extension", []string{".bak", ".wsp.bak"}, "a/c.wsp.bak", "a.c"},
+		{"no matching extension", nil, "a/c.txt", "a.c.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			walk := walkOptions{Extensions: tt.extensions, NoNormalize: true}
+			root := filepath.FromSlash("/srv/whisper")
+			got := walk.metricName(root, filepath.Join(root, filepath.FromSlash(tt.file)))
+			if got != tt.want {
+				t.Errorf("metricName(%q) = %q, want %q", tt.file, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricNameDistinctFiles(t *testing.T) {
+	walk := walkOptions{}
+	a := walk.metricName("/srv/whisper", "/srv/whisper/a/c.wsp")
+	b := walk.metricName("/srv/whisper", "/srv/whisper/a/c.d.wsp")
+	if a == b {
+		t.Errorf("a/c.wsp and a/c.d.wsp both map to %q", a)
+	}
+}
+
+func TestLintRawNamesKeepTrailingDots(t *testing.T) {
+	walk := walkOptions{NoNormalize: true}
+	for file, want := range map[string]string{
+		"/srv/whisper/a/b..wsp":   "a.b.",
+		"/srv/whisper/a/e..f.wsp": "a.e..f",
+	} {
+		metric := walk.metricName("/srv/whisper", file)
+		if metric != want {
+			t.Errorf("metricName(%q) = %q, want %q", file, metric, want)
+		}
+		if len(metricNameProblems(metric)) == 0 {
+			t.Errorf("metricNameProblems(%q) found nothing", metric)
+		}
+	}
+}
+
+func TestDedupeFiles(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.wsp")
+	b := filepath.Join(dir, "b.wsp")
+	link := filepath.Join(dir, "c.wsp")
+	missing := filepath.Join(dir, "missing.wsp")
+	// same schema, so same size, but different files
+	writeTestWhisper(t, a, "1m:1d", whisper.Average, 0.5)
+	writeTestWhisper(t, b, "1m:1d", whisper.Average, 0.5)
+	if err := os.Link(a, link); err != nil {
+		t.Skipf("hardlinks not supported: %v", err)
+	}
+	got := dedupeFiles([]string{a, b, link, missing})
+	want := []string{a, b, missing}
+	if !slices.Equal(got, want) {
+		t.Errorf("dedupeFiles = %v, want %v", got, want)
+	}
+}
+
+func TestMetricNameNormalize(t *testing.T) {
+	tests := []struct {
+		file       string
+		normalized string
+		raw        string
+	}{
+		{"/srv/whisper/a//b.wsp", "a.b", "a.b"},
+		{"/srv/whisper/.a.wsp", "a", ".a"},
+		{"/srv/whisper/a/.b/c..wsp", "a.b.c", "a..b.c."},
+		{"/srv/whisper/web03./cpu.wsp", "web03.cpu", "web03..cpu"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			if got := (walkOptions{}).metricName("/srv/whisper", tt.file); got != tt.normalized {
+				t.Errorf("normalized = %q, want %q", got, tt.normalized)
+			}
+			if got := (walkOptions{NoNormalize: true}).metricName("/srv/whisper", tt.file); got != tt.raw {
+				t.Errorf("with NoNormalize = %q, want %q", got, tt.raw)
+			}
+		})
+	}
+}
+
+func TestMetricNameRelativeAndAbsolute(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	abs := filepath.Join(dir, "whisper", "servers", "web01", "cpu.wsp")
+	rel := filepath.Join(".", "whisper", "servers", "web01", "cpu.wsp")
+	tests := []struct {
+		name, root, file string
+	}{
+		{"relative root, absolute file", "./whisper", abs},
+		{"absolute root, relative file", filepath.Join(dir, "whisper"), rel},
+		{"both relative", "whisper", rel},
+		{"both absolute", filepath.Join(dir, "whisper"), abs},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (walkOptions{}).metricName(tt.root, tt.file); got != "servers.web01.cpu" {
+				t.Errorf("metricName(%q, %q) = %q, want servers.web01.cpu", tt.root, tt.file, got)
+			}
+		})
+	}
+}
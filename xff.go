@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+)
+
+// xffOffset is the byte offset of the xFilesFactor in a whisper header, see archive.go.
+const xffOffset = 8
+
+// xffChange is the xFilesFactor of one file before and after --set-xff.
+type xffChange struct {
+	Metric string
+	Path   string
+	Old    float32
+	New    float32
+}
+
+// setXFilesFactor rewrites the xFilesFactor in the header of the whisper file at path to xff,
+// in place: it is a single field, nothing else in the file depends on it. Without apply the
+// file is only read. It returns the previous value.
+func setXFilesFactor(path string, xff float32, apply bool) (float32, error) {
+	flags := os.O_RDONLY
+	if apply {
+		flags = os.O_RDWR
+	}
+	f, err := os.OpenFile(path, flags, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to close file %s %v\n", path, err)
+		}
+	}()
+
+	h, err := readWhisperHeader(f)
+	if err != nil {
+		return 0, err
+	}
+	if !apply || h.XFilesFactor == xff {
+		return h.XFilesFactor, nil
+	}
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, math.Float32bits(xff))
+	if _, err := f.WriteAt(buf, xffOffset); err != nil {
+		return h.XFilesFactor, err
+	}
+	return h.XFilesFactor, nil
+}
+
+// setTreeXFilesFactor sets the xFilesFactor of every whisper file under root, or of those
+// matching the Graphite glob query when set, to xff and prints a line per file it changes or,
+// with dryRun, would change. Files that already have xff are left alone. Files that can't be
+// read or written are reported on stderr and skipped; the number of them is returned along
+// with the changes.
+func setTreeXFilesFactor(w io.Writer, root, query string, xff float32, dryRun bool, walk walkOptions) ([]xffChange, int, error) {
+	var files []string
+	var err error
+	if query != "" {
+		files, err = expandGraphiteGlob(root, query)
+		files = slices.DeleteFunc(files, func(f string) bool { return !walk.keepMetric(root, f) })
+	} else {
+		files, err = findWhisperFiles(root, walk)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	verb := "set"
+	if dryRun {
+		verb = "would set"
+	}
+	var changes []xffChange
+	failed := 0
+	for _, f := range files {
+		old, err := setXFilesFactor(f, xff, !dryRun)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipping %s: %v\n", f, err)
+			failed++
+			continue
+		}
+		if old == xff {
+			continue
+		}
+		c := xffChange{Metric: walk.metricName(root, f), Path: f, Old: old, New: xff}
+		changes = append(changes, c)
+		_, _ = fmt.Fprintf(w, "%s xFilesFactor of %s from %g to %g\n", verb, c.Metric, c.Old, c.New)
+	}
+	_, _ = fmt.Fprintf(w, "%s xFilesFactor of %d of %d files\n", verb, len(changes), len(files))
+	return changes, failed, nil
+}